@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lonelycode/wasmy/runner"
+)
+
+func inspectCmd(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected a single .wasm file")
+	}
+
+	file := fs.Arg(0)
+	engine := runner.GetEngine()
+	module, err := runner.GetModule(file, engine)
+	if err != nil {
+		return err
+	}
+
+	report := runner.Inspect(module)
+	report.BufferSize, err = runner.ProbeBufferSize(engine, module)
+	if err != nil {
+		return fmt.Errorf("failed to probe buffer size: %w", err)
+	}
+
+	report.ABIVersion, err = runner.ProbeABIVersion(engine, module)
+	if err != nil {
+		return fmt.Errorf("failed to probe ABI version: %w", err)
+	}
+
+	out := &inspectOutput{ModuleReport: report}
+	out.Manifest, err = runner.FindManifest(file)
+	if errors.Is(err, os.ErrNotExist) {
+		out.Manifest = nil
+	} else if err != nil {
+		return err
+	} else {
+		out.ManifestErrors = manifestErrors(out.Manifest.Validate(module))
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(out)
+	}
+
+	err = printReport(os.Stdout, report)
+	if err != nil {
+		return err
+	}
+
+	return printManifest(os.Stdout, out)
+}
+
+// inspectOutput is the report with the manifest found next to the module
+type inspectOutput struct {
+	*runner.ModuleReport
+	Manifest       *runner.Manifest `json:"manifest,omitempty"`
+	ManifestErrors []string         `json:"manifest_errors,omitempty"`
+}
+
+// manifestErrors lists the problems in a Manifest.Validate error
+func manifestErrors(err error) []string {
+	var vErr *runner.ValidationError
+	if !errors.As(err, &vErr) {
+		return nil
+	}
+
+	errs := make([]string, len(vErr.Errs))
+	for i, e := range vErr.Errs {
+		errs[i] = e.Error()
+	}
+
+	return errs
+}
+
+func printManifest(out io.Writer, o *inspectOutput) error {
+	if o.Manifest == nil {
+		_, err := fmt.Fprintln(out, "\nmanifest: none")
+		return err
+	}
+
+	fmt.Fprintf(out, "\nmanifest: %s %s\n", o.Manifest.Name, o.Manifest.Version)
+	if len(o.ManifestErrors) == 0 {
+		_, err := fmt.Fprintln(out, "  matches the module")
+		return err
+	}
+
+	for _, e := range o.ManifestErrors {
+		fmt.Fprintf(out, "  - %s\n", e)
+	}
+
+	return nil
+}
+
+func printReport(out io.Writer, report *runner.ModuleReport) error {
+	if report.Boilerplate {
+		fmt.Fprintln(out, "boilerplate: yes")
+	} else {
+		fmt.Fprintf(out, "boilerplate: no (missing %s)\n", strings.Join(report.MissingBoilerplate, ", "))
+	}
+
+	switch {
+	case report.Allocator:
+		fmt.Fprintln(out, "buffers: allocated per call")
+	case report.BufferSize > 0:
+		fmt.Fprintf(out, "buffers: fixed, %d bytes\n", report.BufferSize)
+	default:
+		fmt.Fprintln(out, "buffers: fixed, size unknown")
+	}
+
+	if report.ABIVersion > 0 {
+		fmt.Fprintf(out, "abi: v%d\n", report.ABIVersion)
+	} else {
+		fmt.Fprintln(out, "abi: unknown, built before wasmy_abi_version")
+	}
+
+	if report.Codec {
+		fmt.Fprintln(out, "codec: negotiated")
+	} else {
+		fmt.Fprintln(out, "codec: msgpack")
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "\nEXPORT\tKIND\tSIGNATURE\tFLAGS")
+	for _, exp := range report.Exports {
+		var flags []string
+		if exp.Managed {
+			flags = append(flags, "managed")
+		}
+		if exp.Boilerplate {
+			flags = append(flags, "boilerplate")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", exp.Name, exp.Kind, exp.Signature, strings.Join(flags, ","))
+	}
+
+	fmt.Fprintln(w, "\nIMPORT\tKIND\tSIGNATURE\tPROVIDER")
+	for _, imp := range report.Imports {
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\n", imp.Module, imp.Name, imp.Kind, imp.Signature, imp.Provider)
+	}
+
+	return w.Flush()
+}
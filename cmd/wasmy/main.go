@@ -0,0 +1,52 @@
+// Command wasmy is a tool for working with wasmy plugins.
+//
+//	wasmy inspect [-json] file.wasm
+//	wasmy run [-dir host[:guest]] [-ro-dir host[:guest]] [-env KEY=VALUE] [-format json|msgpack] [-codec msgpack|json|cbor] [-timeout d] [-n count] [-manifest file] [-stream] file.wasm export [args...]
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"inspect", "[-json] file.wasm", inspectCmd},
+	{"run", "[flags] file.wasm export [args...]", runCmd},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  wasmy %s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		err := cmd.run(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wasmy %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "wasmy: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
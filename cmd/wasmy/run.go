@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go"
+	"github.com/lonelycode/wasmy/runner"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// listFlag collects the values of a flag that can be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runOutput is how a Payload is printed
+type runOutput struct {
+	Data  interface{}             `json:"data"`
+	Meta  map[string]string       `json:"meta,omitempty"`
+	Error *shared_types.Error     `json:"error,omitempty"`
+	Stats *shared_types.CallStats `json:"stats,omitempty"`
+}
+
+func runCmd(args []string) error {
+	var dirs, roDirs, env listFlag
+
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Var(&dirs, "dir", "preopen a host dir for the guest as `host[:guest]`, can be repeated")
+	fs.Var(&roDirs, "ro-dir", "preopen a host dir read-only as `host[:guest]`, can be repeated")
+	fs.Var(&env, "env", "set a guest environment variable as `KEY=VALUE`, can be repeated")
+	format := fs.String("format", "json", "format of the arguments, `json` or `msgpack` (base64 encoded)")
+	timeout := fs.Duration("timeout", 0, "interrupt calls that take longer than this")
+	count := fs.Int("n", 1, "number of times to call the export")
+	codecName := fs.String("codec", "", "talk to the guest in `msgpack`, `json` or `cbor`, by default the guest picks")
+	manifestFile := fs.String("manifest", "", "check the module against this manifest, by default a wasmy.yaml or wasmy.json next to the module is used")
+	streaming := fs.Bool("stream", false, "stream stdin to the export and its stream output to stdout, the final status goes to stderr")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		return fmt.Errorf("expected a .wasm file and an export name")
+	}
+
+	file, export := fs.Arg(0), fs.Arg(1)
+
+	callArgs, err := parseArgs(*format, fs.Args()[2:])
+	if err != nil {
+		return err
+	}
+
+	wasi, err := wasiOptions(dirs, roDirs, env)
+	if err != nil {
+		return err
+	}
+
+	engine := runner.GetEngine()
+	module, err := runner.GetModule(file, engine)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadManifest(*manifestFile, file)
+	if err != nil {
+		return err
+	}
+
+	r := &runner.Runner{WASI: wasi, Manifest: manifest}
+	if *codecName != "" {
+		codec, ok := shared_types.CodecByName(*codecName)
+		if !ok {
+			return fmt.Errorf("unknown codec %q", *codecName)
+		}
+		r.Codec = codec
+	}
+	stubHostImports(r, module)
+
+	err = r.WarmUp(engine, module, nil, export)
+	if err != nil {
+		return err
+	}
+
+	status := os.Stdout
+	if *streaming {
+		status = os.Stderr
+	}
+
+	enc := json.NewEncoder(status)
+	enc.SetEscapeHTML(false)
+
+	failed := 0
+	var lastErr error
+	for i := 0; i < *count; i++ {
+		start := time.Now()
+		out, err := callExport(r, export, *timeout, *streaming, callArgs)
+		took := time.Since(start)
+
+		if *count > 1 {
+			fmt.Fprintf(os.Stderr, "call %d took %s\n", i+1, took)
+		}
+
+		// an interrupted instance can't be called again, later calls get a new one
+		if err != nil && r.Discarded() && *count > 1 {
+			failed++
+			lastErr = err
+			fmt.Fprintf(os.Stderr, "call %d failed: %v\n", i+1, err)
+
+			if i+1 < *count {
+				err = r.WarmUp(engine, module, nil, export)
+				if err != nil {
+					return fmt.Errorf("failed to warm up a new instance after call %d: %w", i+1, err)
+				}
+			}
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = enc.Encode(&runOutput{Data: out.Data, Meta: out.Meta, Error: out.Error, Stats: out.Stats})
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d calls failed, the last with: %w", failed, *count, lastErr)
+	}
+
+	return nil
+}
+
+// callExport makes a single call to export, bounded by timeout if it is set. A
+// streaming call reads stdin and writes its output to stdout.
+func callExport(r *runner.Runner, export string, timeout time.Duration, streaming bool, args []interface{}) (*shared_types.Payload, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
+
+	if streaming {
+		return r.Stream(ctx, export, os.Stdin, os.Stdout, args...)
+	}
+
+	return r.RunContext(ctx, export, args...)
+}
+
+// parseArgs decodes each command line argument into a value for the Args
+// envelope. JSON arguments that are not valid JSON are passed as strings, so
+// `wasmy run plugin.wasm greet martin` works without quoting. msgpack arguments
+// are base64 encoded. An argument starting with @ is read from that file.
+func parseArgs(format string, args []string) ([]interface{}, error) {
+	out := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		raw := []byte(arg)
+		if strings.HasPrefix(arg, "@") {
+			var err error
+			raw, err = os.ReadFile(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var v interface{}
+		var err error
+		switch format {
+		case "json":
+			v, err = parseJSONArg(raw)
+		case "msgpack":
+			v, err = parseMsgpackArg(raw, !strings.HasPrefix(arg, "@"))
+		default:
+			return nil, fmt.Errorf("unknown argument format %q", format)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q: %w", arg, err)
+		}
+
+		out = append(out, v)
+	}
+
+	return out, nil
+}
+
+func parseJSONArg(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return string(raw), nil
+	}
+
+	return fromJSON(v)
+}
+
+// fromJSON replaces the json.Numbers in v with int64 or float64, msgp can't
+// encode them
+func fromJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		for i := range v {
+			el, err := fromJSON(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = el
+		}
+	case map[string]interface{}:
+		for k := range v {
+			el, err := fromJSON(v[k])
+			if err != nil {
+				return nil, err
+			}
+			v[k] = el
+		}
+	}
+
+	return v, nil
+}
+
+func parseMsgpackArg(raw []byte, encoded bool) (interface{}, error) {
+	if encoded {
+		dec, err := base64.StdEncoding.DecodeString(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		raw = dec
+	}
+
+	v, rest, err := msgp.ReadIntfBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(rest))
+	}
+
+	return v, nil
+}
+
+// loadManifest loads the -manifest file, or the manifest next to the module if
+// there is one
+func loadManifest(path, modulePath string) (*runner.Manifest, error) {
+	if path != "" {
+		return runner.LoadManifest(path)
+	}
+
+	m, err := runner.FindManifest(modulePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return m, err
+}
+
+// wasiOptions turns the --dir, --ro-dir and --env options into WASI options
+func wasiOptions(dirs, roDirs, env []string) (*runner.WASIOptions, error) {
+	opts := runner.Sandboxed()
+	addDirs(opts, dirs, runner.ReadWrite)
+	addDirs(opts, roDirs, runner.ReadOnly)
+
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid env %q, want KEY=VALUE", kv)
+		}
+
+		opts.Env(kv[:i], kv[i+1:])
+	}
+
+	return opts, nil
+}
+
+func addDirs(opts *runner.WASIOptions, dirs []string, access runner.DirAccess) {
+	for _, dir := range dirs {
+		host, guest := dir, dir
+		if i := strings.Index(dir, ":"); i >= 0 {
+			host, guest = dir[:i], dir[i+1:]
+		}
+
+		opts.Dir(host, guest, access)
+	}
+}
+
+// stubHostImports satisfies every host import of module with a function that logs
+// its arguments to stderr and returns nil, the real host is not around to
+// provide them
+func stubHostImports(r *runner.Runner, module *wasmtime.Module) {
+	r.HostFunctions = make(map[string]runner.ExportFunc)
+	modules := make(map[string]*runner.HostModule)
+
+	for _, imp := range runner.Inspect(module).Imports {
+		if imp.Kind != "func" {
+			continue
+		}
+
+		name := imp.Module + "/" + imp.Name
+		stub := func(args *shared_types.Args) (interface{}, error) {
+			fmt.Fprintf(os.Stderr, "host call %s %v\n", name, args.Args)
+			return nil, nil
+		}
+
+		// built-in imports like wasmy:stream are linked by the runner itself
+		switch imp.Provider {
+		case runner.ProviderHostFunction:
+			if strings.HasPrefix(imp.Name, "main.") {
+				r.HostFunctions[strings.TrimPrefix(imp.Name, "main.")] = r.WrapExport(stub)
+			}
+		case runner.ProviderHostModule:
+			m, ok := modules[imp.Module]
+			if !ok {
+				m = runner.NewHostModule(imp.Module)
+				modules[imp.Module] = m
+				r.HostModules = append(r.HostModules, m)
+			}
+			m.Define(imp.Name, stub)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestParseArgs(t *testing.T) {
+	args, err := parseArgs("json", []string{"martin", "42", "2.5", `"quoted"`, `{"a":[1,true]}`, "1 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{"martin", int64(42), 2.5, "quoted", map[string]interface{}{"a": []interface{}{int64(1), true}}, "1 2"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("expected %#v, got %#v", want, args)
+	}
+
+	enc, err := msgp.AppendIntf(nil, map[string]interface{}{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err = parseArgs("msgpack", []string{base64.StdEncoding.EncodeToString(enc)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{map[string]interface{}{"a": "b"}}) {
+		t.Errorf("unexpected msgpack args %#v", args)
+	}
+
+	_, err = parseArgs("msgpack", []string{"not base64!"})
+	if err == nil {
+		t.Errorf("expected an error for invalid msgpack")
+	}
+
+	_, err = parseArgs("yaml", []string{"x"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
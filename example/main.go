@@ -1,36 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/bytecodealliance/wasmtime-go"
 	"github.com/lonelycode/wasmy/runner"
 )
 
-func call(engine *wasmtime.Engine, module *wasmtime.Module, arg string) {
-	r := &runner.Runner{}
-
-	// Let's export a sample function into the module
-	r.HostFunctions = map[string]runner.ExportFunc{
-		"PrintHello": r.WrapExport(PrintHello),
-	}
-
-	t3 := time.Now()
-
-	err := r.WarmUp(engine, module, nil, "myExport")
-
-	fmt.Println(r.HostFunctions)
-	if err != nil {
-		fmt.Println("warm-up panic")
-		panic(err)
-	}
-	t4 := time.Since(t3)
-	fmt.Printf("warmup took %s\n", t4)
-
-	// TODO: This breaks when there are no args, handle 0 len args
+func call(pool *runner.Pool, arg string) {
 	t5 := time.Now()
-	out, err := r.Run("myExport", arg)
+	out, err := pool.Run(context.Background(), "myExport", arg)
 	if err != nil {
 		panic(err)
 	}
@@ -40,20 +23,53 @@ func call(engine *wasmtime.Engine, module *wasmtime.Module, arg string) {
 }
 
 func main() {
-	// TODO: make this use application arguments
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: example <managedv2.wasm> [names...]")
+		os.Exit(2)
+	}
+
+	names := os.Args[2:]
+	if len(names) == 0 {
+		names = []string{"martin", "bianca", "ilrud"}
+	}
+
 	t1 := time.Now()
 	engine := wasmtime.NewEngine()
-	module, err := runner.GetModule("/home/vmuser/wasmy/wasm-tests/managedv2.wasm", engine)
+	module, err := runner.GetModule(os.Args[1], engine)
 	if err != nil {
 		panic(err)
 	}
 	t2 := time.Since(t1)
 	fmt.Printf("wasm load took %s\n", t2)
 
-	go call(engine, module, "martin")
-	//go call(engine, module, "bianca")
-	//go call(engine, module, "ilrud")
+	t3 := time.Now()
 
-	time.Sleep(5 * time.Second)
+	// Each instance in the pool gets its own copy of the exported host functions
+	pool, err := runner.NewPool(engine, module, &runner.PoolConfig{
+		Size:    2,
+		MaxSize: 4,
+		HostFunctions: map[string]runner.HostFunc{
+			"PrintHello": PrintHello,
+		},
+		FuncNames: []string{"myExport"},
+	})
+	if err != nil {
+		fmt.Println("warm-up panic")
+		panic(err)
+	}
+	defer pool.Close()
+
+	t4 := time.Since(t3)
+	fmt.Printf("warmup took %s\n", t4)
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			call(pool, name)
+		}(name)
+	}
 
+	wg.Wait()
 }
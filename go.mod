@@ -1,10 +1,14 @@
 module github.com/lonelycode/wasmy
 
-go 1.17
+go 1.18
 
 require (
-	github.com/bytecodealliance/wasmtime-go v0.32.0
+	github.com/bytecodealliance/wasmtime-go v1.0.0
 	github.com/tinylib/msgp v1.1.7-0.20211026165309-e818a1881b0e
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/philhofer/fwd v1.1.2-0.20210816180132-8be6da3b9e29 // indirect
+require (
+	github.com/kr/text v0.2.0 // indirect
+	github.com/philhofer/fwd v1.2.0 // indirect
+)
@@ -0,0 +1,98 @@
+package interfaces
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// StreamModule is the import module of the host functions behind streaming calls
+	StreamModule = "wasmy:stream"
+
+	// StreamChunkSize is the largest chunk moved by a single stream read or write,
+	// it is small enough to fit the fixed buffers of older modules
+	StreamChunkSize = 64 << 10
+)
+
+// StreamReader reads the input of a streaming call (see runner.Runner.Stream) in
+// chunks, every Read asks the host for up to len(p) bytes. Calls made with Run
+// instead of Stream have an empty input.
+type StreamReader struct {
+	proto  *WasmModulePrototype
+	readFn func(int32) int32
+	eof    bool
+}
+
+// NewStreamReader returns a reader for the stream input, readFn is the `read`
+// import of StreamModule
+func NewStreamReader(proto *WasmModulePrototype, readFn func(int32) int32) *StreamReader {
+	return &StreamReader{proto: proto, readFn: readFn}
+}
+
+// Read implements io.Reader
+func (s *StreamReader) Read(p []byte) (int, error) {
+	if s.eof {
+		return 0, io.EOF
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	want := len(p)
+	if want > StreamChunkSize {
+		want = StreamChunkSize
+	}
+
+	ret, err := CallImport(s.proto, s.readFn, want)
+	if err != nil {
+		return 0, err
+	}
+
+	// the host sends a nil result once the input is drained
+	if ret == nil {
+		s.eof = true
+		return 0, io.EOF
+	}
+
+	chunk, ok := ret.([]byte)
+	if !ok || len(chunk) > len(p) {
+		return 0, fmt.Errorf("invalid stream chunk %T of %d bytes", ret, len(chunk))
+	}
+
+	return copy(p, chunk), nil
+}
+
+// StreamWriter writes the output of a streaming call to the host in chunks of at
+// most StreamChunkSize. A write only returns once the host has passed the chunk
+// on, so a guest can never get ahead of a slow consumer.
+type StreamWriter struct {
+	proto   *WasmModulePrototype
+	writeFn func(int32) int32
+}
+
+// NewStreamWriter returns a writer for the stream output, writeFn is the `write`
+// import of StreamModule
+func NewStreamWriter(proto *WasmModulePrototype, writeFn func(int32) int32) *StreamWriter {
+	return &StreamWriter{proto: proto, writeFn: writeFn}
+}
+
+// Write implements io.Writer
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + StreamChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+
+		_, err := CallImport(s.proto, s.writeFn, p[written:end])
+		if err != nil {
+			return written, err
+		}
+
+		written = end
+	}
+
+	return written, nil
+}
@@ -0,0 +1,79 @@
+package interfaces
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// fakeStreamHost implements the `wasmy:stream` imports the way the runner does
+type fakeStreamHost struct {
+	t      *testing.T
+	proto  *WasmModulePrototype
+	in     io.Reader
+	out    bytes.Buffer
+	writes int
+}
+
+func (h *fakeStreamHost) args(n int32) []interface{} {
+	args := &shared_types.Args{}
+	_, err := args.UnmarshalMsg(h.proto.HostInput()[:n])
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	return args.Args
+}
+
+func (h *fakeStreamHost) reply(data interface{}) int32 {
+	if data == nil {
+		return 0
+	}
+
+	enc, _ := (&shared_types.Payload{Data: data}).MarshalMsg(nil)
+	return int32(copy(h.proto.Alloc(len(enc)), enc))
+}
+
+func (h *fakeStreamHost) read(n int32) int32 {
+	buf := make([]byte, h.args(n)[0].(int64))
+	read, _ := h.in.Read(buf)
+	if read == 0 {
+		return h.reply(nil)
+	}
+
+	return h.reply(buf[:read])
+}
+
+func (h *fakeStreamHost) write(n int32) int32 {
+	h.writes++
+	h.out.Write(h.args(n)[0].([]byte))
+	return h.reply(nil)
+}
+
+func TestStreamReaderWriter(t *testing.T) {
+	proto := &WasmModulePrototype{}
+	input := strings.Repeat("0123456789", StreamChunkSize/4)
+	host := &fakeStreamHost{t: t, proto: proto, in: strings.NewReader(input)}
+
+	got, err := io.ReadAll(NewStreamReader(proto, host.read))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != input {
+		t.Fatalf("expected %d bytes of input, got %d", len(input), len(got))
+	}
+
+	n, err := NewStreamWriter(proto, host.write).Write(got)
+	if err != nil || n != len(got) {
+		t.Fatalf("expected %d bytes written, got %d, %v", len(got), n, err)
+	}
+
+	// the output is split into chunks the host can take
+	if host.out.String() != input || host.writes != 3 {
+		t.Fatalf("expected the input back in 3 chunks, got %d bytes in %d chunks", host.out.Len(), host.writes)
+	}
+}
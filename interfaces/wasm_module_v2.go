@@ -4,70 +4,203 @@
 package interfaces
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"os"
+	"reflect"
 
 	shared_types "github.com/lonelycode/wasmy/shared-types"
-	"github.com/tinylib/msgp/msgp"
 )
 
 const (
-	// FUNCBUFFER_SIZE sets the maximum size of I/O
-	// buffers used to pass data between host and
-	// guest and vice versa
+	// FUNCBUFFER_SIZE is the size of the fixed I/O buffers of modules built before
+	// buffers were allocated per call, the host still limits the payloads of those
+	// modules to it
 	FUNCBUFFER_SIZE = 1344000
 )
 
-// WasmModulePrototype provides a wrapper for managing I/O for WASM modules
+var (
+	// ErrPayloadTooLarge is returned when a length does not fit in the buffer it
+	// refers to, it is shared_types.ErrPayloadTooLarge
+	ErrPayloadTooLarge = shared_types.ErrPayloadTooLarge
+
+	// ErrHostCall is returned by CallImport when the host function failed without
+	// being able to report why
+	ErrHostCall = errors.New("host function call failed")
+)
+
+// WasmModulePrototype provides a wrapper for managing I/O for WASM modules. Buffers
+// are allocated for every call with exactly the size of the data in them:
+//
+//   - the host allocates the input of an exported function and the output of an
+//     imported one with Alloc (exported as `wasmy_alloc`), the guest takes
+//     ownership of that buffer when it reads it
+//   - the output of an exported function and the input of an imported one are
+//     allocated by the guest and reported by Output and HostInput
+//
+// Every imported function call runs in its own call frame, so a host function that
+// calls back into the guest gets a fresh set of buffers for the nested export call
+// and the buffers of the caller are still there when it returns. A single prototype
+// is safe for nested and re-entrant calls, there is no need for one per export.
+//
+// The zero value is ready to use and speaks msgpack.
 type WasmModulePrototype struct {
-	guestFnInputBfr  [FUNCBUFFER_SIZE]uint8 // exported Fn input buffer
-	guestFnOutputBfr [FUNCBUFFER_SIZE]uint8 // exported Fn output buffer
+	// Codec encodes the Args and Payload envelopes, nil is shared_types.Msgpack.
+	// The host picks it up through the `wasmy_codec` export, see NegotiateCodec.
+	Codec shared_types.Codec
+
+	// allocs pins the buffers handed out by Alloc until they are read or freed,
+	// keyed by their first byte
+	allocs map[*byte][]byte
+	// frames is the call frame stack, the last frame belongs to the innermost
+	// imported function call
+	frames []callFrame
+}
+
+// callFrame holds the buffers of a single level of calls between the guest and
+// the host
+type callFrame struct {
+	// pending is the last buffer allocated by the host
+	pending []byte
+
+	guestFnOutputBfr []byte // exported Fn output buffer
+	hostFnInputBfr   []byte // imported Fn input buffer
+}
+
+// frame returns the innermost call frame, the pointer is only valid until the
+// next push
+func (d *WasmModulePrototype) frame() *callFrame {
+	if len(d.frames) == 0 {
+		d.frames = append(d.frames, callFrame{})
+	}
+
+	return &d.frames[len(d.frames)-1]
+}
+
+// push starts a call frame for an imported function call
+func (d *WasmModulePrototype) push() {
+	d.frame()
+	d.frames = append(d.frames, callFrame{})
+}
+
+// pop drops the innermost call frame and releases a host buffer that was never
+// read, e.g. the input of a nested call that failed before reading it
+func (d *WasmModulePrototype) pop() {
+	f := d.frame()
+	if len(f.pending) > 0 {
+		delete(d.allocs, &f.pending[0])
+	}
+
+	d.frames[len(d.frames)-1] = callFrame{}
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+// NegotiateCodec is the `wasmy_codec` export, the host offers the codecs it
+// supports as a shared_types.CodecMask and gets the ID of the prototype codec
+// back, or -1 when it doesn't offer it
+func (d *WasmModulePrototype) NegotiateCodec(offered int32) int32 {
+	id := shared_types.OrDefault(d.Codec).ID()
+	if offered&(1<<id) == 0 {
+		return -1
+	}
+
+	return int32(id)
+}
+
+// Depth returns the number of imported function calls in progress
+func (d *WasmModulePrototype) Depth() int {
+	if len(d.frames) == 0 {
+		return 0
+	}
 
-	hostFnInputBfr  [FUNCBUFFER_SIZE]uint8 // imported Fn input buffer
-	hostFnOutputBfr [FUNCBUFFER_SIZE]uint8 // imported Fn input buffer
+	return len(d.frames) - 1
 }
 
-// GetInputPtr will return a pointer to the `guestFnInputBfr` in
-// WASM linear memory, this buffer provides arg input to functions
-// exported by the WASM module in the form of shared_types.Args
-func (d *WasmModulePrototype) GetInputPtr() *[FUNCBUFFER_SIZE]uint8 {
-	return &d.guestFnInputBfr
+// Alloc allocates a buffer of size bytes for the host to write into and keeps it
+// alive until it is read or freed, it returns nil for a size <= 0
+func (d *WasmModulePrototype) Alloc(size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+
+	if d.allocs == nil {
+		d.allocs = make(map[*byte][]byte)
+	}
+
+	// a pending buffer nobody read, e.g. because the host failed before calling
+	// the export, is replaced rather than kept alive
+	f := d.frame()
+	if len(f.pending) > 0 {
+		delete(d.allocs, &f.pending[0])
+	}
+
+	buf := make([]byte, size)
+	d.allocs[&buf[0]] = buf
+	f.pending = buf
+
+	return buf
+}
+
+// Free releases a buffer returned by Alloc that was never read
+func (d *WasmModulePrototype) Free(ptr *byte) {
+	delete(d.allocs, ptr)
+
+	if f := d.frame(); len(f.pending) > 0 && &f.pending[0] == ptr {
+		f.pending = nil
+	}
+}
+
+// Pending returns the last buffer allocated by the host, it is the input of the
+// exported function being called or the output of the imported function that just
+// returned
+func (d *WasmModulePrototype) Pending() []byte {
+	return d.frame().pending
 }
 
-// GetOutputPtr will return a pointer to the `guestFnOutputBfr` in
-// WASM linear memory, this buffer provides output for functions
-// exported by the WASM module in the form of shared_types.Payload
-func (d *WasmModulePrototype) GetOutputPtr() *[FUNCBUFFER_SIZE]uint8 {
-	return &d.guestFnOutputBfr
+// Output returns the output of the last exported function call as a Payload
+func (d *WasmModulePrototype) Output() []byte {
+	return d.frame().guestFnOutputBfr
 }
 
-// GetHostOutputPtr will return a pointer to the `hostFnOutputBfr` in
-// WASM linear memory, this buffer provides output for functions
-// imported by the WASM module in the form of shared_types.Payload
-func (d *WasmModulePrototype) GetHostOutputPtr() *[FUNCBUFFER_SIZE]uint8 {
-	return &d.hostFnOutputBfr
+// HostInput returns the args of the last imported function call as an Args object
+func (d *WasmModulePrototype) HostInput() []byte {
+	return d.frame().hostFnInputBfr
 }
 
-// GetHostInputPtr will return a pointer to the `hostFnInputBfr` in
-// WASM linear memory, this buffer provides input for functions
-// imported by the WASM module in the form of shared_types.Args
-func (d *WasmModulePrototype) GetHostInputPtr() *[FUNCBUFFER_SIZE]uint8 {
-	return &d.hostFnInputBfr
+// takePending hands the first length bytes of the pending buffer to the caller and
+// releases the buffer
+func (d *WasmModulePrototype) takePending(length int) ([]byte, error) {
+	f := d.frame()
+	buf := f.pending
+	f.pending = nil
+	if len(buf) > 0 {
+		delete(d.allocs, &buf[0])
+	}
+
+	if length < 0 || length > len(buf) {
+		return nil, fmt.Errorf("%w: %d bytes, buffer is %d bytes", ErrPayloadTooLarge, length, len(buf))
+	}
+
+	return buf[:length], nil
 }
 
 // ReadGuestFnInput will read the input buffer for any WASM-exported functions
-// as `shared_types.Args` and return the argument array to the caller
+// as `shared_types.Args` and return the argument array to the caller, a length
+// of 0 is a call without args
 func (d *WasmModulePrototype) ReadGuestFnInput(length int) ([]interface{}, error) {
-	dat := make([]byte, length)
-	copy(dat, d.guestFnInputBfr[:length])
+	if length == 0 {
+		return nil, nil
+	}
+
+	dat, err := d.takePending(length)
+	if err != nil {
+		return nil, err
+	}
 
 	args := &shared_types.Args{
 		Args: make([]interface{}, 0),
 	}
 
-	buf := bytes.NewBuffer(dat)
-	err := msgp.Decode(buf, args)
+	err = shared_types.OrDefault(d.Codec).Unmarshal(dat, args)
 	if err != nil {
 		return nil, err
 	}
@@ -77,13 +210,18 @@ func (d *WasmModulePrototype) ReadGuestFnInput(length int) ([]interface{}, error
 
 // ReadHostFnOutput will read the output buffer for host functions (imported by WASM module)and
 // returns an error. The function takes an output interface pointer in order to easily modify
-// the payload type by the caller.
+// the payload type by the caller. A length of 0 is a nil result and leaves output empty.
 func (d *WasmModulePrototype) ReadHostFnOutput(length int, output *shared_types.Payload) error {
-	dat := make([]byte, length)
-	copy(dat, d.hostFnOutputBfr[:length])
+	if length == 0 {
+		return nil
+	}
 
-	buf := bytes.NewBuffer(dat)
-	err := msgp.Decode(buf, output)
+	dat, err := d.takePending(length)
+	if err != nil {
+		return err
+	}
+
+	err = shared_types.OrDefault(d.Codec).Unmarshal(dat, output)
 	if err != nil {
 		return err
 	}
@@ -91,60 +229,85 @@ func (d *WasmModulePrototype) ReadHostFnOutput(length int, output *shared_types.
 	return nil
 }
 
-// WriteGuestFnOutput writes WASM-exported function output into the guest buffer as a Payload
+// WriteGuestFnOutput writes WASM-exported function output into the guest buffer as a Payload,
+// a nil result without meta is not written at all and returns 0
 func (d *WasmModulePrototype) WriteGuestFnOutput(data interface{}, meta map[string]string) (int, error) {
+	if data == nil && meta == nil {
+		d.frame().guestFnOutputBfr = nil
+		return 0, nil
+	}
+
 	out := &shared_types.Payload{Data: data, Meta: meta}
 
-	enc, err := out.MarshalMsg(nil)
+	enc, err := shared_types.OrDefault(d.Codec).Marshal(out)
 	if err != nil {
 		return 0, err
 	}
-	copy(d.guestFnOutputBfr[:len(enc)], enc)
+
+	d.frame().guestFnOutputBfr = enc
 	return len(enc), nil
 }
 
 // WriteHostFnInput will write the args for a WASM-imported function into the host
-// input buffer as an Args object
+// input buffer as an Args object, no args are not written at all and return 0
 func (d *WasmModulePrototype) WriteHostFnInput(args []interface{}) (int, error) {
+	if len(args) == 0 {
+		d.frame().hostFnInputBfr = nil
+		return 0, nil
+	}
+
 	out := &shared_types.Args{Args: args}
 
-	enc, err := out.MarshalMsg(nil)
+	enc, err := shared_types.OrDefault(d.Codec).Marshal(out)
 	if err != nil {
 		return 0, err
 	}
-	copy(d.hostFnInputBfr[:len(enc)], enc)
+
+	d.frame().hostFnInputBfr = enc
 	return len(enc), nil
 }
 
-// externGuestErr is sup;poed to provide an error buffer, TODO: still unsure if this idea
-// is worth pursuing for managed error output from called funcs
-func (d *WasmModulePrototype) externGuestErr(err error) int {
-	errTp := fmt.Sprintf("ERR %s", err.Error())
-	os.Stderr.WriteString(errTp)
-	copy(d.guestFnOutputBfr[:], []byte(errTp))
+// externGuestErr writes err into the guest output buffer as the Error envelope of
+// a Payload so the host can return it as a typed error. If err is (or wraps) a
+// *shared_types.Error its code and details are kept, otherwise code is used.
+func (d *WasmModulePrototype) externGuestErr(code string, err error) int {
+	gErr := &shared_types.Error{}
+	if !errors.As(err, &gErr) {
+		gErr = &shared_types.Error{Code: code, Message: err.Error()}
+	}
+
+	codec := shared_types.OrDefault(d.Codec)
+	enc, mErr := codec.Marshal(&shared_types.Payload{Error: gErr})
+	if mErr != nil {
+		// details that can't be encoded are dropped so the host still sees the error
+		enc, _ = codec.Marshal(&shared_types.Payload{Error: &shared_types.Error{Code: gErr.Code, Message: gErr.Message}})
+	}
 
-	return len([]byte(errTp))
+	d.frame().guestFnOutputBfr = enc
+	return len(enc)
 }
 
 // WrapExport takes a prototype (managed buffers) object and a caller function, it will
 // write the args to the guest input buffer, run the function, and capture the return data
 // from the guest function to write into the output buffer. It returns the length of the data
-// written in order for the caller to pull the correct data from the buffer.
+// written in order for the caller to pull the correct data from the buffer. Errors are
+// written as the Payload.Error envelope, return a *shared_types.Error from exportFn to
+// set your own error code and details.
 func WrapExport(proto *WasmModulePrototype, inputLen int, exportFn func(args ...interface{}) (interface{}, map[string]string, error)) func() int {
 	return func() int {
 		args, err := proto.ReadGuestFnInput(inputLen)
 		if err != nil {
-			return proto.externGuestErr(err)
+			return proto.externGuestErr(shared_types.ErrCodeDecode, err)
 		}
 
 		ret, meta, err := exportFn(args...)
 		if err != nil {
-			return proto.externGuestErr(err)
+			return proto.externGuestErr(shared_types.ErrCodeGuest, err)
 		}
 
 		n, err := proto.WriteGuestFnOutput(ret, meta)
 		if err != nil {
-			return proto.externGuestErr(err)
+			return proto.externGuestErr(shared_types.ErrCodeEncode, err)
 		}
 
 		return n
@@ -153,9 +316,14 @@ func WrapExport(proto *WasmModulePrototype, inputLen int, exportFn func(args ...
 
 // CallImport will take a managed buffer prototype, imported function and arguments and
 // writes the args to the host input buffer, it will then capture the output of the function
-// from the host output buffer, unmarshal it and return it to the caller as a Payload.
-// TODO: Maybe it should return the payload.Data interface instead?
+// from the host output buffer, unmarshal it and return the Payload data to the caller.
+// Errors from the host function are returned as a *shared_types.Error. The call runs
+// in a new call frame, exports called by the host while it runs don't touch the
+// buffers of the caller.
 func CallImport(proto *WasmModulePrototype, fn func(int32) int32, args ...interface{}) (interface{}, error) {
+	proto.push()
+	defer proto.pop()
+
 	// Write our args to the host input buffer
 	lenInp, err := proto.WriteHostFnInput(args)
 	if err != nil {
@@ -164,6 +332,9 @@ func CallImport(proto *WasmModulePrototype, fn func(int32) int32, args ...interf
 
 	// call the imported function with the length of the input data
 	lenOut := fn(int32(lenInp))
+	if lenOut < 0 {
+		return nil, ErrHostCall
+	}
 
 	// Read the host output buffer for the return value
 	output := &shared_types.Payload{}
@@ -172,5 +343,77 @@ func CallImport(proto *WasmModulePrototype, fn func(int32) int32, args ...interf
 		return nil, err
 	}
 
+	if output.Error != nil {
+		return nil, output.Error
+	}
+
 	return output.Data, nil
 }
+
+// ExportTyped is WrapExport for a guest function with a single typed argument and
+// result. The arg sent by the host is decoded into In with shared_types.FromWire,
+// a bad input is returned to the host as a decode_error instead of panicking, and
+// anything but exactly one arg as a bad_args error.
+func ExportTyped[In, Out any](proto *WasmModulePrototype, inputLen int, exportFn func(In) (Out, error)) func() int {
+	return WrapExport(proto, inputLen, func(args ...interface{}) (interface{}, map[string]string, error) {
+		if len(args) != 1 {
+			return nil, nil, &shared_types.Error{Code: shared_types.ErrCodeBadArgs, Message: fmt.Sprintf("expected 1 argument, got %d", len(args))}
+		}
+
+		var in In
+		err := shared_types.FromWire(args[0], &in)
+		if err != nil {
+			return nil, nil, &shared_types.Error{Code: shared_types.ErrCodeDecode, Message: err.Error()}
+		}
+
+		out, err := exportFn(in)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ret, err := shared_types.ToWire(out)
+		if err != nil {
+			return nil, nil, &shared_types.Error{Code: shared_types.ErrCodeEncode, Message: err.Error()}
+		}
+
+		return ret, nil, nil
+	})
+}
+
+// ImportTyped is CallImport for a host function with a single typed argument and
+// result, the host output is decoded into Out with shared_types.FromWire. A host
+// function that returns no result is a bad_args error, unless Out can be nil.
+func ImportTyped[In, Out any](proto *WasmModulePrototype, fn func(int32) int32, in In) (Out, error) {
+	var out Out
+
+	arg, err := shared_types.ToWire(in)
+	if err != nil {
+		return out, err
+	}
+
+	ret, err := CallImport(proto, fn, arg)
+	if err != nil {
+		return out, err
+	}
+
+	if ret == nil && !nilable(reflect.TypeOf(&out).Elem()) {
+		return out, &shared_types.Error{Code: shared_types.ErrCodeBadArgs, Message: fmt.Sprintf("host function returned no result, expected %T", out)}
+	}
+
+	err = shared_types.FromWire(ret, &out)
+	if err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+// nilable reports whether a value of type t can be nil
+func nilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,356 @@
+package interfaces
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// readGuestOutput decodes the guest output buffer the way the host does
+func readGuestOutput(t *testing.T, proto *WasmModulePrototype, n int) *shared_types.Payload {
+	t.Helper()
+
+	out := &shared_types.Payload{}
+	_, err := out.UnmarshalMsg(proto.Output()[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+func TestWrapExportError(t *testing.T) {
+	proto := &WasmModulePrototype{}
+
+	args, _ := (&shared_types.Args{Args: []interface{}{"martin"}}).MarshalMsg(nil)
+	copy(proto.Alloc(len(args)), args)
+
+	n := WrapExport(proto, len(args), func(args ...interface{}) (interface{}, map[string]string, error) {
+		return nil, nil, errors.New("boom")
+	})()
+
+	out := readGuestOutput(t, proto, n)
+	if out.Error == nil || out.Error.Code != shared_types.ErrCodeGuest || out.Error.Message != "boom" {
+		t.Fatalf("unexpected error envelope %+v", out.Error)
+	}
+
+	copy(proto.Alloc(len(args)), args)
+	n = WrapExport(proto, len(args), func(args ...interface{}) (interface{}, map[string]string, error) {
+		return nil, nil, &shared_types.Error{Code: "custom", Message: "bad", Details: map[string]string{"k": "v"}}
+	})()
+
+	out = readGuestOutput(t, proto, n)
+	if out.Error == nil || out.Error.Code != "custom" || out.Error.Details["k"] != "v" {
+		t.Fatalf("unexpected error envelope %+v", out.Error)
+	}
+
+	// a length without an input buffer is reported as a decode error
+	n = WrapExport(proto, len(args), func(args ...interface{}) (interface{}, map[string]string, error) {
+		return nil, nil, nil
+	})()
+
+	out = readGuestOutput(t, proto, n)
+	if out.Error == nil || out.Error.Code != shared_types.ErrCodeDecode {
+		t.Fatalf("unexpected error envelope %+v", out.Error)
+	}
+}
+
+func TestCallImportError(t *testing.T) {
+	proto := &WasmModulePrototype{}
+
+	_, err := CallImport(proto, func(int32) int32 { return -1 }, "martin")
+	if !errors.Is(err, ErrHostCall) {
+		t.Fatalf("expected ErrHostCall, got %v", err)
+	}
+
+	// a host function that reports an error in the Payload envelope
+	_, err = CallImport(proto, func(int32) int32 {
+		enc, _ := (&shared_types.Payload{Error: &shared_types.Error{Code: shared_types.ErrCodeHost, Message: "host says no"}}).MarshalMsg(nil)
+		return int32(copy(proto.Alloc(len(enc)), enc))
+	}, "martin")
+
+	var hErr *shared_types.Error
+	if !errors.As(err, &hErr) || hErr.Message != "host says no" {
+		t.Fatalf("expected the host error, got %v", err)
+	}
+}
+
+func TestExportTyped(t *testing.T) {
+	type greeting struct {
+		Name  string `msg:"name"`
+		Times int    `msg:"times"`
+	}
+
+	proto := &WasmModulePrototype{}
+	greet := func(in greeting) (string, error) {
+		return fmt.Sprintf("hello %s x%d", in.Name, in.Times), nil
+	}
+
+	args, _ := (&shared_types.Args{Args: []interface{}{map[string]interface{}{"name": "martin", "times": 2}}}).MarshalMsg(nil)
+	copy(proto.Alloc(len(args)), args)
+
+	out := readGuestOutput(t, proto, ExportTyped(proto, len(args), greet)())
+	if out.Error != nil || out.Data != "hello martin x2" {
+		t.Fatalf("unexpected output %+v", out)
+	}
+
+	// a bad input is a decode error rather than a panic
+	args, _ = (&shared_types.Args{Args: []interface{}{map[string]interface{}{"times": "lots"}}}).MarshalMsg(nil)
+	copy(proto.Alloc(len(args)), args)
+
+	out = readGuestOutput(t, proto, ExportTyped(proto, len(args), greet)())
+	if out.Error == nil || out.Error.Code != shared_types.ErrCodeDecode {
+		t.Fatalf("expected a decode error, got %+v", out)
+	}
+
+	// a missing or extra arg isn't zero-filled or dropped
+	for _, argv := range [][]interface{}{nil, {map[string]interface{}{"name": "a"}, "extra"}} {
+		args, _ = (&shared_types.Args{Args: argv}).MarshalMsg(nil)
+		copy(proto.Alloc(len(args)), args)
+
+		out = readGuestOutput(t, proto, ExportTyped(proto, len(args), greet)())
+		if out.Error == nil || out.Error.Code != shared_types.ErrCodeBadArgs {
+			t.Fatalf("expected a bad args error for %v, got %+v", argv, out)
+		}
+	}
+}
+
+func TestImportTyped(t *testing.T) {
+	proto := &WasmModulePrototype{}
+
+	// a host function that returns the length of its string argument
+	hostLen := func(n int32) int32 {
+		args := &shared_types.Args{}
+		_, err := args.UnmarshalMsg(proto.HostInput()[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		enc, _ := (&shared_types.Payload{Data: len(args.Args[0].(string))}).MarshalMsg(nil)
+		return int32(copy(proto.Alloc(len(enc)), enc))
+	}
+
+	n, err := ImportTyped[string, uint8](proto, hostLen, "anderson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 8 {
+		t.Fatalf("expected 8, got %d", n)
+	}
+
+	_, err = ImportTyped[string, string](proto, hostLen, "anderson")
+	if err == nil {
+		t.Fatal("expected a decode error")
+	}
+
+	// no result is only fine for an Out that can be nil
+	hostNil := func(n int32) int32 {
+		enc, _ := (&shared_types.Payload{}).MarshalMsg(nil)
+		return int32(copy(proto.Alloc(len(enc)), enc))
+	}
+
+	_, err = ImportTyped[string, uint8](proto, hostNil, "anderson")
+	sErr := &shared_types.Error{}
+	if !errors.As(err, &sErr) || sErr.Code != shared_types.ErrCodeBadArgs {
+		t.Fatalf("expected a bad args error, got %v", err)
+	}
+
+	p, err := ImportTyped[string, *string](proto, hostNil, "anderson")
+	if err != nil || p != nil {
+		t.Fatalf("expected a nil result, got %v, %v", p, err)
+	}
+}
+
+func TestEmptyEnvelopes(t *testing.T) {
+	proto := &WasmModulePrototype{}
+
+	// no args and a nil result are both sent as a length of 0
+	var got []interface{}
+	n := WrapExport(proto, 0, func(args ...interface{}) (interface{}, map[string]string, error) {
+		got = args
+		return nil, nil, nil
+	})()
+
+	if n != 0 || len(got) != 0 {
+		t.Fatalf("expected no args and no output, got %v and %d bytes", got, n)
+	}
+
+	// meta is still sent without data
+	n = WrapExport(proto, 0, func(args ...interface{}) (interface{}, map[string]string, error) {
+		return nil, map[string]string{"k": "v"}, nil
+	})()
+
+	out := readGuestOutput(t, proto, n)
+	if out.Data != nil || out.Meta["k"] != "v" {
+		t.Fatalf("unexpected output %+v", out)
+	}
+
+	var inLen int32 = -1
+	ret, err := CallImport(proto, func(n int32) int32 {
+		inLen = n
+		return 0
+	})
+	if err != nil || ret != nil || inLen != 0 {
+		t.Fatalf("expected a nil result for an empty call, got %v, %v (input %d bytes)", ret, err, inLen)
+	}
+
+	// a nil arg is still an arg
+	_, err = CallImport(proto, func(n int32) int32 {
+		inLen = n
+		return 0
+	}, nil)
+	if err != nil || inLen == 0 {
+		t.Fatalf("expected the nil arg to be sent, got %v (input %d bytes)", err, inLen)
+	}
+}
+
+func TestAlloc(t *testing.T) {
+	proto := &WasmModulePrototype{}
+
+	if proto.Alloc(0) != nil {
+		t.Fatal("expected no buffer for an empty allocation")
+	}
+
+	// freed before it was read
+	buf := proto.Alloc(8)
+	proto.Free(&buf[0])
+	if len(proto.allocs) != 0 || proto.Pending() != nil {
+		t.Fatalf("expected the buffer to be released, got %d allocs", len(proto.allocs))
+	}
+
+	// the host failed after allocating and never called the export, the next
+	// allocation releases the buffer it left behind
+	proto.Alloc(8)
+	buf = proto.Alloc(16)
+	if len(proto.allocs) != 1 || len(proto.Pending()) != 16 {
+		t.Fatalf("expected only the new buffer, got %d allocs", len(proto.allocs))
+	}
+	proto.Free(&buf[0])
+
+	// reading the input takes ownership of the buffer
+	args, _ := (&shared_types.Args{Args: []interface{}{"martin"}}).MarshalMsg(nil)
+	copy(proto.Alloc(len(args)), args)
+
+	got, err := proto.ReadGuestFnInput(len(args))
+	if err != nil || len(got) != 1 || got[0] != "martin" {
+		t.Fatalf("unexpected input %v, %v", got, err)
+	}
+
+	if len(proto.allocs) != 0 || proto.Pending() != nil {
+		t.Fatalf("expected the input buffer to be released, got %d allocs", len(proto.allocs))
+	}
+
+	// a length past the end of the buffer is rejected
+	copy(proto.Alloc(len(args)), args)
+	_, err = proto.ReadGuestFnInput(len(args) + 1)
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+}
+
+func TestReentrantCalls(t *testing.T) {
+	proto := &WasmModulePrototype{}
+
+	hostArg := func(n int32) int {
+		args := &shared_types.Args{}
+		_, err := args.UnmarshalMsg(proto.HostInput()[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var k int
+		shared_types.FromWire(args.Args[0], &k)
+		return k
+	}
+
+	// countdown calls the host, which calls countdown again with one less until it
+	// gets to 0, so every level has its own host input, guest input and output
+	var countdown func(k int) (string, error)
+	maxDepth := 0
+
+	down := func(n int32) int32 {
+		k := hostArg(n)
+		if d := proto.Depth(); d > maxDepth {
+			maxDepth = d
+		}
+
+		args, _ := (&shared_types.Args{Args: []interface{}{k - 1}}).MarshalMsg(nil)
+		copy(proto.Alloc(len(args)), args)
+		inner := readGuestOutput(t, proto, ExportTyped(proto, len(args), countdown)())
+
+		// the host input of this level survived the nested call
+		if got := hostArg(n); got != k {
+			t.Errorf("expected host input %d after the nested call, got %d", k, got)
+		}
+
+		enc, _ := (&shared_types.Payload{Data: inner.Data}).MarshalMsg(nil)
+		return int32(copy(proto.Alloc(len(enc)), enc))
+	}
+
+	countdown = func(k int) (string, error) {
+		if k == 0 {
+			return "0", nil
+		}
+
+		ret, err := ImportTyped[int, string](proto, down, k)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%d,%s", k, ret), nil
+	}
+
+	args, _ := (&shared_types.Args{Args: []interface{}{3}}).MarshalMsg(nil)
+	copy(proto.Alloc(len(args)), args)
+
+	out := readGuestOutput(t, proto, ExportTyped(proto, len(args), countdown)())
+	if out.Error != nil || out.Data != "3,2,1,0" {
+		t.Fatalf("unexpected output %+v", out)
+	}
+
+	if maxDepth != 3 || proto.Depth() != 0 || len(proto.allocs) != 0 {
+		t.Fatalf("expected 3 nested frames and none left, got %d, %d (%d allocs)", maxDepth, proto.Depth(), len(proto.allocs))
+	}
+
+	// a nested input that was never read is released with its frame
+	_, err := CallImport(proto, func(n int32) int32 {
+		proto.Alloc(8)
+		return -1
+	})
+	if !errors.Is(err, ErrHostCall) || len(proto.allocs) != 0 {
+		t.Fatalf("expected ErrHostCall and no allocs, got %v (%d allocs)", err, len(proto.allocs))
+	}
+}
+
+func TestCodec(t *testing.T) {
+	proto := &WasmModulePrototype{Codec: shared_types.CBOR}
+
+	if id := proto.NegotiateCodec(shared_types.CodecMask(shared_types.Codecs...)); id != int32(shared_types.CodecCBOR) {
+		t.Fatalf("expected CBOR to be picked, got %d", id)
+	}
+
+	if id := proto.NegotiateCodec(shared_types.CodecMask(shared_types.Msgpack)); id != -1 {
+		t.Fatalf("expected no codec in common, got %d", id)
+	}
+
+	args, _ := shared_types.CBOR.Marshal(&shared_types.Args{Args: []interface{}{"martin"}})
+	copy(proto.Alloc(len(args)), args)
+
+	n := ExportTyped(proto, len(args), func(name string) (string, error) {
+		return "hello " + name, nil
+	})()
+
+	out := &shared_types.Payload{}
+	err := shared_types.CBOR.Unmarshal(proto.Output()[:n], out)
+	if err != nil || out.Data != "hello martin" {
+		t.Fatalf("unexpected output %+v, %v", out, err)
+	}
+
+	// the zero value speaks msgpack
+	if id := (&WasmModulePrototype{}).NegotiateCodec(-1); id != int32(shared_types.CodecMsgpack) {
+		t.Fatalf("expected msgpack, got %d", id)
+	}
+}
@@ -1,32 +1,68 @@
 package module_params
 
-import "github.com/lonelycode/wasmy/interfaces"
+import (
+	"github.com/lonelycode/wasmy/interfaces"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
 
 //==========  START BOILERPLATE ==========//
 
 // This variable will provide all the i/o we need for this module
 var Proto *interfaces.WasmModulePrototype = &interfaces.WasmModulePrototype{}
 
-// This is required so we can do I/O
+// This is required so we can do I/O, the host allocates buffers for the data it
+// sends us and reads what we send it from the buffer exports
+
+//export wasmy_alloc
+func Alloc(size int32) *byte {
+	return bufferPtr(Proto.Alloc(int(size)))
+}
+
+//export wasmy_free
+func Free(ptr *byte) {
+	Proto.Free(ptr)
+}
+
+// The host checks which version of the wire format we were built against
+
+//export wasmy_abi_version
+func ABIVersion() int32 {
+	return shared_types.ABIVersion
+}
+
+// The host asks which codec we speak, set Proto.Codec to change it
+
+//export wasmy_codec
+func Codec(offered int32) int32 {
+	return Proto.NegotiateCodec(offered)
+}
 
 //export inputBuffer
-func InputBuffer() *[interfaces.FUNCBUFFER_SIZE]uint8 {
-	return Proto.GetInputPtr()
+func InputBuffer() *byte {
+	return bufferPtr(Proto.Pending())
 }
 
 //export outputBuffer
-func OutputBuffer() *[interfaces.FUNCBUFFER_SIZE]uint8 {
-	return Proto.GetOutputPtr()
+func OutputBuffer() *byte {
+	return bufferPtr(Proto.Output())
 }
 
 //export hostInputBuffer
-func HostInputBuffer() *[interfaces.FUNCBUFFER_SIZE]uint8 {
-	return Proto.GetHostInputPtr()
+func HostInputBuffer() *byte {
+	return bufferPtr(Proto.HostInput())
 }
 
 //export hostOutputBuffer
-func HostOutputBuffer() *[interfaces.FUNCBUFFER_SIZE]uint8 {
-	return Proto.GetHostOutputPtr()
+func HostOutputBuffer() *byte {
+	return bufferPtr(Proto.Pending())
+}
+
+func bufferPtr(buf []byte) *byte {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	return &buf[0]
 }
 
 //==========  END BOILERPLATE ==========//
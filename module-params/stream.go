@@ -0,0 +1,24 @@
+//go:build tinygo
+
+package module_params
+
+import "github.com/lonelycode/wasmy/interfaces"
+
+// The host functions behind streaming calls, TinyGo only imports them into
+// modules that use StreamInput or StreamOutput
+
+//go:wasmimport wasmy:stream read
+func streamRead(int32) int32
+
+//go:wasmimport wasmy:stream write
+func streamWrite(int32) int32
+
+// StreamInput returns the input of a streaming call
+func StreamInput() *interfaces.StreamReader {
+	return interfaces.NewStreamReader(Proto, streamRead)
+}
+
+// StreamOutput returns the output of a streaming call
+func StreamOutput() *interfaces.StreamWriter {
+	return interfaces.NewStreamWriter(Proto, streamWrite)
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"fmt"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// abiVersionExport reports the shared_types.ABIVersion a module was built against
+const abiVersionExport = "wasmy_abi_version"
+
+// abiSupport is a row of the compatibility matrix, how the host talks to a guest
+// built against an older ABI
+type abiSupport struct {
+	version int32
+	// legacyEnvelopes makes the host send an envelope for every call, the guest
+	// can't take a length of 0
+	legacyEnvelopes bool
+}
+
+// abiMatrix lists every guest ABI version this host can run
+var abiMatrix = []abiSupport{
+	{version: shared_types.ABIv1, legacyEnvelopes: true},
+	{version: shared_types.ABIv2},
+	{version: shared_types.ABIv3},
+}
+
+// checkABI reads the ABI version of the guest and sets up the adapter for it. A
+// module built before the `wasmy_abi_version` export existed is taken to be ABIv3
+// when it has the allocator exports and ABIv1 otherwise, ABIv1 is the safe guess
+// as every ABIv2 guest can also take the envelopes it is sent.
+func (r *Runner) checkABI() error {
+	version := shared_types.ABIv1
+	if fn := r.instance.GetFunc(r.store, abiVersionExport); fn != nil {
+		res, err := fn.Call(r.store)
+		if err != nil {
+			return fmt.Errorf("failed to call %s: %w", abiVersionExport, err)
+		}
+
+		version, _ = res.(int32)
+	} else if r.bufs.Dynamic() {
+		version = shared_types.ABIv3
+	}
+
+	for _, abi := range abiMatrix {
+		if abi.version == version {
+			r.abi = version
+			r.bufs.LegacyEnvelopes = abi.legacyEnvelopes
+			return nil
+		}
+	}
+
+	if version > shared_types.ABIVersion {
+		return fmt.Errorf("%w: module was built for ABI v%d, this host supports up to v%d, upgrade the host", ErrABIVersion, version, shared_types.ABIVersion)
+	}
+
+	return fmt.Errorf("%w: module reports ABI v%d, this host supports v%d to v%d", ErrABIVersion, version, abiMatrix[0].version, shared_types.ABIVersion)
+}
+
+// ABIVersion returns the ABI version of the guest, it is set by WarmUp
+func (r *Runner) ABIVersion() int32 {
+	return r.abi
+}
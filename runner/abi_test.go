@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// testABIGuestWAT is a fixed buffer guest that reports the ABI version in %[5]s,
+// `callHost` passes its input to `echo` and returns the host output
+const testABIGuestWAT = `
+(module
+  (import "env" "main.echo" (func $echo (param i32 i32 i32) (result i32)))
+  (memory (export "memory") 100)
+  (func (export "inputBuffer") (result i32) (i32.const %[1]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[2]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[4]d))
+  %[5]s
+  (func (export "callHost") (param $len i32) (result i32)
+    (local $n i32)
+    (memory.copy (i32.const %[3]d) (i32.const %[1]d) (local.get $len))
+    (local.set $n (call $echo (local.get $len) (i32.const 0) (i32.const 0)))
+    (memory.copy (i32.const %[2]d) (i32.const %[4]d) (local.get $n))
+    (local.get $n))
+)`
+
+func TestCheckABI(t *testing.T) {
+	engine := GetEngine()
+	abiModule := func(export string) *wasmtime.Module {
+		wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testABIGuestWAT,
+			testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer, export))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		module, err := wasmtime.NewModule(engine, wasm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return module
+	}
+
+	version := func(v int32) string {
+		return fmt.Sprintf(`(func (export "wasmy_abi_version") (result i32) (i32.const %d))`, v)
+	}
+
+	tests := []struct {
+		name    string
+		export  string
+		abi     int32
+		legacy  bool
+		wantErr error
+	}{
+		{name: "no export", export: "", abi: shared_types.ABIv1, legacy: true},
+		{name: "v1", export: version(1), abi: shared_types.ABIv1, legacy: true},
+		{name: "v2", export: version(2), abi: shared_types.ABIv2},
+		{name: "newer", export: version(shared_types.ABIVersion + 1), wantErr: ErrABIVersion},
+		{name: "bogus", export: version(0), wantErr: ErrABIVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inLen, outLen int32
+			r := &Runner{}
+			wrapped := r.WrapExport(echo)
+			r.HostFunctions = map[string]ExportFunc{
+				"echo": func(dataLen, t2, t3 int32) int32 {
+					inLen = dataLen
+					outLen = wrapped(dataLen, t2, t3)
+					return outLen
+				},
+			}
+
+			err := r.WarmUp(engine, abiModule(tt.export), nil, "callHost")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.ABIVersion() != tt.abi {
+				t.Fatalf("expected ABI v%d, got v%d", tt.abi, r.ABIVersion())
+			}
+
+			// no args and a nil result, only sent as a length of 0 from ABIv2 on
+			out, err := r.Run("callHost")
+			if err != nil || out.Data != nil {
+				t.Fatalf("expected an empty payload, got %+v, %v", out, err)
+			}
+
+			if (inLen != 0 && outLen != 0) != tt.legacy {
+				t.Fatalf("expected legacy envelopes to be %v, sent %d and %d bytes", tt.legacy, inLen, outLen)
+			}
+		})
+	}
+
+	v, err := ProbeABIVersion(engine, abiModule(version(2)))
+	if err != nil || v != 2 {
+		t.Fatalf("expected to probe v2, got %d, %v", v, err)
+	}
+}
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+const (
+	// cacheExt is the extension of serialized modules in a ModuleCache dir
+	cacheExt = ".cwasm"
+
+	wasmtimeModule = "github.com/bytecodealliance/wasmtime-go"
+)
+
+// ModuleCache persists compiled modules to a directory so that a module only has
+// to be compiled once, later loads deserialize the compiled code instead.
+//
+// Entries are keyed by a hash of the wasm bytes, the wasmtime version and the
+// engine config, so a changed module, a wasmtime upgrade or different engine
+// flags never pick up a stale entry. The directory must only be writable by
+// trusted users: deserialized code is run as-is.
+type ModuleCache struct {
+	Dir string
+	// StoreError gets the errors of storing a compiled module, they don't fail
+	// the load, nil writes them to stderr
+	StoreError func(path string, err error)
+}
+
+// NewModuleCache creates dir if needed and returns a cache stored in it
+func NewModuleCache(dir string) (*ModuleCache, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ModuleCache{Dir: dir}, nil
+}
+
+// GetModule is runner.GetModule backed by the cache
+func (c *ModuleCache) GetModule(filename string, engine *wasmtime.Engine) (*wasmtime.Module, error) {
+	wasm, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.LoadModule(wasm, engine)
+}
+
+// LoadModule returns the compiled module for wasm, compiling and storing it on a
+// cache miss. An entry that can't be deserialized (e.g. it was truncated, or the
+// engine rejects it) is recompiled and replaced. Storing is best effort, a module
+// that compiled is returned even if it couldn't be cached (see StoreError).
+func (c *ModuleCache) LoadModule(wasm []byte, engine *wasmtime.Engine) (*wasmtime.Module, error) {
+	path := c.entryPath(wasm, engine)
+
+	// the entry is read rather than mapped, a mapped entry that is truncated while
+	// the module is alive takes the process down with SIGBUS
+	if enc, err := os.ReadFile(path); err == nil {
+		module, err := wasmtime.NewModuleDeserialize(engine, enc)
+		if err == nil {
+			return module, nil
+		}
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.store(path, module)
+	if err != nil {
+		c.storeError(path, err)
+	}
+
+	return module, nil
+}
+
+func (c *ModuleCache) storeError(path string, err error) {
+	if c.StoreError != nil {
+		c.StoreError(path, err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "wasmy: module compiled but not cached in %s: %v\n", path, err)
+}
+
+// Clear removes every entry from the cache
+func (c *ModuleCache) Clear() error {
+	entries, err := filepath.Glob(filepath.Join(c.Dir, "*"+cacheExt))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err := os.Remove(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// store serializes module into path, it is written to a temp file and renamed so
+// that a concurrent or interrupted write never leaves a partial entry behind
+func (c *ModuleCache) store(path string, module *wasmtime.Module) error {
+	enc, err := module.Serialize()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(enc)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// entryPath returns the cache file for wasm compiled by engine
+func (c *ModuleCache) entryPath(wasm []byte, engine *wasmtime.Engine) string {
+	h := sha256.New()
+	h.Write(wasm)
+	fmt.Fprintf(h, "\x00wasmtime=%s\x00engine=%s", wasmtimeVersion(), engineFingerprint(engine))
+
+	return filepath.Join(c.Dir, hex.EncodeToString(h.Sum(nil))+cacheExt)
+}
+
+// engineFingerprint describes the engine settings that change generated code, for
+// engines that were not created with NewEngine wasmtime's own compatibility check
+// on deserialize is all that protects the entry
+func engineFingerprint(engine *wasmtime.Engine) string {
+	state := getEngineState(engine)
+	if state == nil {
+		return "external"
+	}
+
+	return fmt.Sprintf("epoch=%t,fuel=%t", state.cfg.EpochInterruption, state.cfg.ConsumeFuel)
+}
+
+// wasmtimeVersion returns the wasmtime-go version this binary was built with
+func wasmtimeVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == wasmtimeModule {
+			if dep.Replace != nil {
+				return dep.Replace.Path + "@" + dep.Replace.Version
+			}
+
+			return dep.Version
+		}
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+func TestModuleCache(t *testing.T) {
+	cache, err := NewModuleCache(filepath.Join(t.TempDir(), "modules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wasm, err := wasmtime.Wat2Wasm(`(module (func (export "answer") (result i32) (i32.const 42)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := func() []string {
+		files, _ := filepath.Glob(filepath.Join(cache.Dir, "*"+cacheExt))
+		return files
+	}
+
+	answer := func(engine *wasmtime.Engine, module *wasmtime.Module) {
+		t.Helper()
+
+		store := wasmtime.NewStore(engine)
+		store.SetEpochDeadline(noEpochDeadline)
+		instance, err := wasmtime.NewInstance(store, module, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ret, err := instance.GetFunc(store, "answer").Call(store)
+		if err != nil || ret.(int32) != 42 {
+			t.Fatalf("expected 42, got %v (%v)", ret, err)
+		}
+	}
+
+	engine := GetEngine()
+	module, err := cache.LoadModule(wasm, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer(engine, module)
+
+	if len(entries()) != 1 {
+		t.Fatalf("expected a single cache entry, got %v", entries())
+	}
+
+	// a second load deserializes the entry, a different engine config gets its own
+	other := GetEngine()
+	module, err = cache.LoadModule(wasm, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer(other, module)
+
+	fuelEngine := NewEngine(&EngineConfig{ConsumeFuel: true})
+	_, err = cache.LoadModule(wasm, fuelEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries()) != 2 {
+		t.Fatalf("expected an entry per engine config, got %v", entries())
+	}
+
+	// a corrupt entry is recompiled and replaced
+	path := cache.entryPath(wasm, engine)
+	err = os.WriteFile(path, []byte("garbage"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err = cache.LoadModule(wasm, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer(engine, module)
+
+	if fi, _ := os.Stat(path); fi.Size() == int64(len("garbage")) {
+		t.Fatal("expected the corrupt entry to be replaced")
+	}
+
+	err = cache.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries()) != 0 {
+		t.Fatalf("expected an empty cache, got %v", entries())
+	}
+
+	// a module that can't be stored is still returned
+	var storeErr error
+	broken := &ModuleCache{
+		Dir:        filepath.Join(t.TempDir(), "missing"),
+		StoreError: func(_ string, err error) { storeErr = err },
+	}
+
+	module, err = broken.LoadModule(wasm, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer(engine, module)
+
+	if storeErr == nil {
+		t.Fatal("expected a store error")
+	}
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// Call calls the guest function name with a single typed argument and decodes the
+// Payload data into Out, see CallContext
+func Call[In, Out any](r *Runner, name string, in In) (Out, error) {
+	return CallContext[In, Out](context.Background(), r, name, in)
+}
+
+// CallContext calls the guest function name with in as its only argument and decodes
+// the Payload data into Out. Structs are sent as maps keyed by their `msg` tags (see
+// shared_types.ToWire), so the guest should decode them into a struct with the same
+// tags, e.g. with interfaces.ExportTyped.
+func CallContext[In, Out any](ctx context.Context, r *Runner, name string, in In) (Out, error) {
+	return typedCall[In, Out](in, func(arg interface{}) (*shared_types.Payload, error) {
+		return r.RunContext(ctx, name, arg)
+	})
+}
+
+// PoolCall is CallContext for a Runner checked out from p
+func PoolCall[In, Out any](ctx context.Context, p *Pool, name string, in In) (Out, error) {
+	return typedCall[In, Out](in, func(arg interface{}) (*shared_types.Payload, error) {
+		return p.Run(ctx, name, arg)
+	})
+}
+
+// CallBack is HostCallContext.Run with a single typed argument and result, for host
+// functions that call back into the guest
+func CallBack[In, Out any](c *HostCallContext, name string, in In) (Out, error) {
+	return typedCall[In, Out](in, func(arg interface{}) (*shared_types.Payload, error) {
+		return c.Run(name, arg)
+	})
+}
+
+func typedCall[In, Out any](in In, run func(interface{}) (*shared_types.Payload, error)) (Out, error) {
+	var out Out
+
+	arg, err := shared_types.ToWire(in)
+	if err != nil {
+		return out, fmt.Errorf("failed to encode input: %w", err)
+	}
+
+	payload, err := run(arg)
+	if err != nil {
+		return out, err
+	}
+
+	err = shared_types.FromWire(payload.Data, &out)
+	if err != nil {
+		return out, fmt.Errorf("failed to decode output: %w", err)
+	}
+
+	return out, nil
+}
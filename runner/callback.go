@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// DefaultMaxCallDepth is the number of guest calls that can be nested through
+// HostCallContexts when Runner.MaxCallDepth is not set, the outermost call counts
+const DefaultMaxCallDepth = 8
+
+// ContextHostFunc is a HostFunc that can call back into the guest that called it,
+// wrap it with Runner.WrapContextExport or register it with HostModule.DefineContext
+type ContextHostFunc func(*HostCallContext, *shared_types.Args) (interface{}, error)
+
+// HostCallContext gives a host function access to the exports of the instance that
+// called it, for example to have the guest map over items the host produces:
+//
+//	func mapItems(cc *runner.HostCallContext, args *shared_types.Args) (interface{}, error) {
+//		var out []interface{}
+//		for _, item := range items {
+//			ret, err := cc.Run("mapItem", item)
+//			if err != nil {
+//				return nil, err
+//			}
+//			out = append(out, ret.Data)
+//		}
+//		return out, nil
+//	}
+//
+// Nested calls share the deadline and fuel budget of the outermost call. A
+// HostCallContext is only valid until the host function returns.
+type HostCallContext struct {
+	r   *Runner
+	ctx context.Context
+	// closed is set once the host function returned
+	closed bool
+}
+
+// Context returns the context of the outermost call
+func (c *HostCallContext) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// Depth returns the number of guest calls in progress, the guest function that
+// called the host function included
+func (c *HostCallContext) Depth() int {
+	return c.r.depth
+}
+
+// Run calls the guest function name like Runner.Run while the guest is still
+// waiting for the host function to return. Going deeper than Runner.MaxCallDepth
+// returns ErrCallDepthExceeded.
+func (c *HostCallContext) Run(name string, args ...interface{}) (*shared_types.Payload, error) {
+	if c.closed {
+		return nil, ErrHostCallDone
+	}
+
+	r := c.r
+	if r.discarded {
+		return nil, ErrInstanceDiscarded
+	}
+
+	max := r.MaxCallDepth
+	if max <= 0 {
+		max = DefaultMaxCallDepth
+	}
+
+	if r.depth >= max {
+		return nil, fmt.Errorf("%w: %d nested calls", ErrCallDepthExceeded, max)
+	}
+
+	fn, ok := r.FuncMap[name]
+	if !ok {
+		return nil, fmt.Errorf("function name not found")
+	}
+
+	return r.call(c.Context(), name, fn, args...)
+}
+
+func (c *HostCallContext) done() {
+	c.closed = true
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// callbackRunner warms up the test guest with a context host function as `echo`
+func callbackRunner(t *testing.T, fn ContextHostFunc) *Runner {
+	t.Helper()
+
+	engine := GetEngine()
+	r := &Runner{}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapContextExport(fn),
+	}
+
+	err := r.WarmUp(engine, testModule(t, engine, &shared_types.Payload{Data: "ok"}), nil, "constant", "callHost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestHostCallContext(t *testing.T) {
+	// countdown calls callHost with one less until it gets to 0, so callHost is
+	// re-entered by its own host function
+	depths := []int{}
+	var r *Runner
+	r = callbackRunner(t, func(cc *HostCallContext, args *shared_types.Args) (interface{}, error) {
+		var n int
+		err := shared_types.FromWire(args.Args[0], &n)
+		if err != nil {
+			return nil, err
+		}
+
+		depths = append(depths, cc.Depth())
+		if n == 0 {
+			// the guest maps over the items, constant stands in for the mapping
+			ret, err := CallBack[string, string](cc, "constant", "item")
+			return "bottom:" + ret, err
+		}
+
+		out, err := cc.Run("callHost", n-1)
+		if err != nil {
+			return nil, err
+		}
+
+		return fmt.Sprintf("%d>%v", n, out.Data), nil
+	})
+
+	out, err := r.Run("callHost", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != "3>2>1>bottom:ok" {
+		t.Fatalf("unexpected output %v", out.Data)
+	}
+
+	if fmt.Sprint(depths) != "[1 2 3 4]" || r.depth != 0 {
+		t.Fatalf("unexpected call depths %v, %d left", depths, r.depth)
+	}
+
+	// the limit counts the outermost call
+	r.MaxCallDepth = 2
+	_, err = r.Run("callHost", 3)
+
+	gErr := &GuestError{}
+	if !errors.As(err, &gErr) || !strings.Contains(gErr.Message, ErrCallDepthExceeded.Error()) {
+		t.Fatalf("expected the depth limit to reach the host, got %v", err)
+	}
+
+	// the runner can still be used afterwards, within the limit
+	out, err = r.Run("callHost", 0)
+	if err != nil || out.Data != "bottom:ok" {
+		t.Fatalf("unexpected output %+v, %v", out, err)
+	}
+}
+
+func TestHostCallContextGuards(t *testing.T) {
+	var r *Runner
+	var runErr error
+	var kept *HostCallContext
+	r = callbackRunner(t, func(cc *HostCallContext, args *shared_types.Args) (interface{}, error) {
+		kept = cc
+
+		// calling the runner directly instead of through cc
+		_, runErr = r.Run("constant")
+		return nil, nil
+	})
+
+	_, err := r.Run("callHost", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(runErr, ErrReentrantCall) {
+		t.Fatalf("expected ErrReentrantCall, got %v", runErr)
+	}
+
+	_, err = kept.Run("constant")
+	if !errors.Is(err, ErrHostCallDone) {
+		t.Fatalf("expected ErrHostCallDone, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// OutputCapture redirects the stdout and stderr of an instance away from the host
+// process so that plugin output can be told apart from the host's own logs.
+//
+// Output is passed on per call, not live: the lines of a call reach Writer in one
+// go once the call returns, fails or is interrupted (or WarmUp finishes), a long
+// running call shows nothing before that. wasmtime-go
+// v1 only lets WASI write to files and has no write hook, so there is nothing to
+// stream from while the guest runs.
+type OutputCapture struct {
+	// Writer gets every line the guest writes once its call returns, tagged with
+	// Name, the call ID and the stream as `[name call=N stdout] line`. Runners in
+	// a Pool share it, so it has to be safe for concurrent use there. Nil only
+	// keeps the output for Return.
+	Writer io.Writer
+	// Name tags every line, e.g. the name of the plugin
+	Name string
+	// Return sets Payload.Output on every successful call
+	Return bool
+}
+
+// lastCallID numbers the calls of every Runner in the process, so a call ID in the
+// captured output is unique even when a Pool shares the Writer
+var lastCallID uint64
+
+func nextCallID() uint64 {
+	return atomic.AddUint64(&lastCallID, 1)
+}
+
+// capture spools the guest output of an instance to unlinked temp files, WASI in
+// wasmtime-go can only write to files. Lines are passed on once a call returns,
+// and the spool files are emptied again.
+type capture struct {
+	cfg    *OutputCapture
+	stdout *os.File
+	stderr *os.File
+	// helper rewinds the guest side of the spool files, nil leaves them growing
+	helper *wasiHelper
+}
+
+// newCapture points the stdout and stderr of conf at fresh spool files
+func newCapture(cfg *OutputCapture, conf *wasmtime.WasiConfig) (*capture, error) {
+	c := &capture{cfg: cfg}
+
+	var err error
+	c.stdout, err = spool(conf.SetStdoutFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture guest stdout: %w", err)
+	}
+
+	c.stderr, err = spool(conf.SetStderrFile)
+	if err != nil {
+		c.stdout.Close()
+		return nil, fmt.Errorf("failed to capture guest stderr: %w", err)
+	}
+
+	return c, nil
+}
+
+// spool creates a temp file, has WASI open it with set and unlinks it, so it is
+// gone once both sides closed it. The returned file reads what the guest wrote.
+func spool(set func(string) error) (*os.File, error) {
+	f, err := os.CreateTemp("", "wasmy-output-*")
+	if err != nil {
+		return nil, err
+	}
+
+	err = set(f.Name())
+	os.Remove(f.Name())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// flush passes on the output written since the last flush as the output of call id
+func (c *capture) flush(id uint64) (*shared_types.CallOutput, error) {
+	out := &shared_types.CallOutput{CallID: id}
+
+	var err error
+	out.Stdout, err = c.drain(c.stdout, wasiStdout, "stdout", id)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Stderr, err = c.drain(c.stderr, wasiStderr, "stderr", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// drain reads the new output of a stream and writes it to the Writer a line at
+// a time, a last line without a newline still ends with the call
+func (c *capture) drain(f *os.File, fd int32, stream string, id uint64) ([]byte, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guest %s: %w", stream, err)
+	}
+
+	if len(data) > 0 {
+		err = c.reset(f, fd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reset guest %s: %w", stream, err)
+		}
+	}
+
+	if c.cfg.Writer == nil || len(data) == 0 {
+		return data, nil
+	}
+
+	rest := data
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = nil
+		}
+
+		_, err = fmt.Fprintf(c.cfg.Writer, "[%s call=%d %s] %s\n", c.cfg.Name, id, stream, line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write guest %s: %w", stream, err)
+		}
+	}
+
+	return data, nil
+}
+
+// reset empties a spool file once it has been read. WASI has the file open with
+// an offset of its own, so the guest fd is rewound as well, otherwise the next
+// write would leave a hole the size of everything written so far. A store that
+// can't run the helper anymore (e.g. after an interrupt) keeps its output.
+func (c *capture) reset(f *os.File, fd int32) error {
+	if c.helper == nil || c.helper.call("rewind", fd) != nil {
+		return nil
+	}
+
+	err := f.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// close closes the host side of the spool files, WASI closes its own with the store
+func (c *capture) close() {
+	c.stdout.Close()
+	c.stderr.Close()
+}
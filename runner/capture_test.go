@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+// testCaptureGuestWAT writes "hello\nwor" to stdout and "oops\n" to stderr on
+// every call to `say`
+const testCaptureGuestWAT = `
+(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $write (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 100)
+  (data (i32.const 512) "\00\04\00\00\09\00\00\00\50\04\00\00\05\00\00\00")
+  (data (i32.const 1024) "hello\nwor")
+  (data (i32.const 1104) "oops\n")
+  (func (export "inputBuffer") (result i32) (i32.const %[1]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[2]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[4]d))
+  (func (export "wasmy_abi_version") (result i32) (i32.const 2))
+  (func (export "say") (param $len i32) (result i32)
+    (drop (call $write (i32.const 1) (i32.const 512) (i32.const 1) (i32.const 600)))
+    (drop (call $write (i32.const 2) (i32.const 520) (i32.const 1) (i32.const 600)))
+    (i32.const 0))
+)`
+
+func TestCapture(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testCaptureGuestWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log bytes.Buffer
+	r := &Runner{Capture: &OutputCapture{Writer: &log, Name: "greeter", Return: true}}
+	err = r.WarmUp(engine, module, nil, "say")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastID uint64
+	for i := 0; i < 2; i++ {
+		log.Reset()
+		out, err := r.Run("say")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every call only gets its own output
+		got := out.Output
+		if got == nil || string(got.Stdout) != "hello\nwor" || string(got.Stderr) != "oops\n" {
+			t.Fatalf("unexpected output %+v", got)
+		}
+
+		if got.CallID <= lastID {
+			t.Fatalf("expected a new call ID, got %d after %d", got.CallID, lastID)
+		}
+		lastID = got.CallID
+
+		want := fmt.Sprintf("[greeter call=%[1]d stdout] hello\n[greeter call=%[1]d stdout] wor\n[greeter call=%[1]d stderr] oops\n", got.CallID)
+		if log.String() != want {
+			t.Fatalf("expected the log\n%s\ngot\n%s", want, log.String())
+		}
+
+		// the spool files are emptied after every call
+		for _, f := range []*os.File{r.capture.stdout, r.capture.stderr} {
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fi.Size() != 0 {
+				t.Fatalf("expected an empty spool file, got %d bytes", fi.Size())
+			}
+		}
+	}
+
+	// warming up again closes the spool files of the old instance
+	old := r.capture
+	err = r.WarmUp(engine, module, nil, "say")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := old.stdout.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected the old spool file to be closed, got %v", err)
+	}
+}
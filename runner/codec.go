@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// codecExport is the optional export a guest uses to pick its codec, it gets the
+// offered codecs as a shared_types.CodecMask and returns the ID of the codec it
+// speaks, or -1 if none of them
+const codecExport = "wasmy_codec"
+
+// negotiateCodec asks the guest which codec it speaks. A guest without the export
+// is assumed to speak Runner.Codec, so modules built before codecs were pluggable
+// keep speaking msgpack.
+func (r *Runner) negotiateCodec() error {
+	offered := shared_types.Codecs
+	if r.Codec != nil {
+		offered = []shared_types.Codec{r.Codec}
+	}
+
+	fn := r.instance.GetFunc(r.store, codecExport)
+	if fn == nil {
+		r.codec = shared_types.OrDefault(r.Codec)
+		return nil
+	}
+
+	// the negotiation may run before the first call topped up the store
+	if r.fuelEnabled() {
+		err := r.setFuel(unlimitedFuel)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := fn.Call(r.store, shared_types.CodecMask(offered...))
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", codecExport, err)
+	}
+
+	id, _ := res.(int32)
+	for _, c := range offered {
+		if c.ID() == shared_types.CodecID(id) {
+			r.codec = c
+			return nil
+		}
+	}
+
+	names := make([]string, len(offered))
+	for i, c := range offered {
+		names[i] = c.Name()
+	}
+
+	return fmt.Errorf("%w: offered %s, guest answered %d", ErrCodecMismatch, strings.Join(names, ", "), id)
+}
+
+// wireCodec returns the codec agreed on in WarmUp, or Runner.Codec for a Runner
+// that was set up by hand
+func (r *Runner) wireCodec() shared_types.Codec {
+	if r.codec != nil {
+		return r.codec
+	}
+
+	return shared_types.OrDefault(r.Codec)
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// testCodecGuestWAT speaks JSON, `callHost` passes its input to `echo` untouched
+// so the host does all of the encoding
+const testCodecGuestWAT = `
+(module
+  (import "env" "main.echo" (func $echo (param i32 i32 i32) (result i32)))
+  (memory (export "memory") 100)
+  (func (export "inputBuffer") (result i32) (i32.const %[1]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[2]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[4]d))
+  (func (export "wasmy_abi_version") (result i32) (i32.const 3))
+  (func (export "wasmy_codec") (param $offered i32) (result i32)
+    (if (result i32) (i32.and (local.get $offered) (i32.const 2))
+      (then (i32.const 1))
+      (else (i32.const -1))))
+  (func (export "callHost") (param $len i32) (result i32)
+    (local $n i32)
+    (memory.copy (i32.const %[3]d) (i32.const %[1]d) (local.get $len))
+    (local.set $n (call $echo (local.get $len) (i32.const 0) (i32.const 0)))
+    (memory.copy (i32.const %[2]d) (i32.const %[4]d) (local.get $n))
+    (local.get $n))
+)`
+
+func TestNegotiateCodec(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testCodecGuestWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw []byte
+	r := &Runner{}
+	wrapped := r.WrapExport(echo)
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": func(dataLen, t2, t3 int32) int32 {
+			raw = append([]byte(nil), r.mem.UnsafeData(r.store)[testHostInputBuffer:testHostInputBuffer+dataLen]...)
+			return wrapped(dataLen, t2, t3)
+		},
+	}
+
+	err = r.WarmUp(engine, module, nil, "callHost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.codec != shared_types.JSON {
+		t.Fatalf("expected the guest to pick JSON, got %s", r.codec.Name())
+	}
+
+	out, err := r.Run("callHost", map[string]interface{}{"n": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(raw) != `{"args":[{"n":3}]}` {
+		t.Errorf("expected JSON args on the wire, got %q", raw)
+	}
+
+	if m, ok := out.Data.(map[string]interface{}); !ok || m["n"] != int64(3) {
+		t.Errorf("unexpected output %#v", out.Data)
+	}
+
+	// the guest doesn't speak CBOR
+	r2 := &Runner{Codec: shared_types.CBOR}
+	r2.HostFunctions = map[string]ExportFunc{"echo": r2.WrapExport(echo)}
+	err = r2.WarmUp(engine, module, nil, "callHost")
+	if !errors.Is(err, ErrCodecMismatch) {
+		t.Fatalf("expected ErrCodecMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package runner
+
+import "fmt"
+
+// PageSize is the size of a WASM linear memory page
+const PageSize = 65536
+
+// RunnerConfig sets the memory limits of the instances a Runner creates, all
+// sizes are in 64KiB WASM pages
+type RunnerConfig struct {
+	// MinPages is the smallest memory an instance may have once warmed up,
+	// WarmUp fails if the guest memory can't be grown to this size
+	MinPages uint64
+	// InitialPages is the size the guest memory is grown to during WarmUp, 0
+	// leaves it at the size the module declares
+	InitialPages uint64
+	// MaxPages is the memory quota of an instance, 0 means no quota. It is only a
+	// hard limit for modules compiled with CompileModule, which caps their memory
+	// at it, other guests can grow past it and are only caught, and discarded,
+	// once control returns to the host
+	MaxPages uint64
+}
+
+// MemoryUsage describes the linear memory of an instance
+type MemoryUsage struct {
+	Pages    uint64
+	Bytes    uint64
+	MaxPages uint64
+}
+
+// setupMemory grows the guest memory to the configured initial size and checks
+// it against the min and max limits
+func (r *Runner) setupMemory() error {
+	if r.Config == nil {
+		return nil
+	}
+
+	cfg := r.Config
+	if cfg.MaxPages > 0 && (cfg.InitialPages > cfg.MaxPages || cfg.MinPages > cfg.MaxPages) {
+		return fmt.Errorf("%w: min %d and initial %d pages must not exceed max %d pages",
+			ErrMemoryLimitExceeded, cfg.MinPages, cfg.InitialPages, cfg.MaxPages)
+	}
+
+	target := cfg.InitialPages
+	if target < cfg.MinPages {
+		target = cfg.MinPages
+	}
+
+	size := r.mem.Size(r.store)
+	if target > size {
+		_, err := r.mem.Grow(r.store, target-size)
+		if err != nil {
+			return fmt.Errorf("failed to grow guest memory to %d pages: %w", target, err)
+		}
+	}
+
+	return r.checkMemory()
+}
+
+// checkMemory returns ErrMemoryLimitExceeded if the guest memory is above its quota
+func (r *Runner) checkMemory() error {
+	if r.Config == nil || r.Config.MaxPages == 0 {
+		return nil
+	}
+
+	size := r.mem.Size(r.store)
+	if size > r.Config.MaxPages {
+		return fmt.Errorf("%w: %d pages in use, quota is %d pages", ErrMemoryLimitExceeded, size, r.Config.MaxPages)
+	}
+
+	return nil
+}
+
+// MemoryUsage reports the current linear memory size of the instance, it must
+// not be called while a guest call is in progress on another goroutine
+func (r *Runner) MemoryUsage() MemoryUsage {
+	usage := MemoryUsage{}
+	if r.mem == nil {
+		return usage
+	}
+
+	usage.Pages = r.mem.Size(r.store)
+	usage.Bytes = uint64(r.mem.DataSize(r.store))
+	if r.Config != nil {
+		usage.MaxPages = r.Config.MaxPages
+	}
+
+	return usage
+}
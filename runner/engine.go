@@ -0,0 +1,158 @@
+package runner
+
+import (
+	"sync"
+	"time"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+const (
+	// DefaultEpochInterval is the default deadline resolution of RunContext
+	DefaultEpochInterval = 10 * time.Millisecond
+
+	// noEpochDeadline is the epoch deadline (in ticks) given to a store when
+	// a call has no deadline, at the default interval it is several centuries
+	noEpochDeadline = 1 << 40
+)
+
+// EngineConfig sets the wasmtime features of an engine created with NewEngine
+type EngineConfig struct {
+	// EpochInterruption lets RunContext interrupt guest calls once their
+	// context deadline has passed
+	EpochInterruption bool
+	// EpochInterval is how often the engine epoch is incremented while calls
+	// with a deadline are in flight, it is the resolution of deadlines
+	EpochInterval time.Duration
+	// ConsumeFuel enables fuel metering, every guest call is then limited by
+	// Runner.FuelBudget and reports the fuel it consumed in Payload.Stats
+	ConsumeFuel bool
+}
+
+// DefaultEngineConfig is the config used by GetEngine
+func DefaultEngineConfig() *EngineConfig {
+	return &EngineConfig{
+		EpochInterruption: true,
+		EpochInterval:     DefaultEpochInterval,
+	}
+}
+
+// engineState tracks the config and epoch ticker of every engine created by
+// NewEngine, keyed by *wasmtime.Engine, until it is dropped by ReleaseEngine
+var engineStates sync.Map
+
+type engineState struct {
+	cfg    EngineConfig
+	ticker *epochTicker
+
+	// helper is wasiHelperWAT compiled for the engine, see wasiHelperModule
+	helperOnce sync.Once
+	helper     *wasmtime.Module
+	helperErr  error
+}
+
+// NewEngine creates a wasmtime engine with the features in cfg enabled, a nil
+// cfg uses DefaultEngineConfig. The engine is registered with the runner until
+// ReleaseEngine is called, so create one per config and share it rather than
+// creating one per call.
+func NewEngine(cfg *EngineConfig) *wasmtime.Engine {
+	if cfg == nil {
+		cfg = DefaultEngineConfig()
+	}
+
+	state := &engineState{cfg: *cfg}
+	if state.cfg.EpochInterval <= 0 {
+		state.cfg.EpochInterval = DefaultEpochInterval
+	}
+
+	wConf := wasmtime.NewConfig()
+	wConf.SetEpochInterruption(state.cfg.EpochInterruption)
+	wConf.SetConsumeFuel(state.cfg.ConsumeFuel)
+
+	engine := wasmtime.NewEngineWithConfig(wConf)
+	state.ticker = &epochTicker{engine: engine, interval: state.cfg.EpochInterval}
+	engineStates.Store(engine, state)
+
+	return engine
+}
+
+// ReleaseEngine drops the state NewEngine keeps for engine, without it the engine
+// is never garbage collected. Call it once no Runner, Pool or ModuleCache uses the
+// engine anymore, afterwards it is treated like an engine created directly with
+// wasmtime. The shared engine of GetEngine is never released.
+func ReleaseEngine(engine *wasmtime.Engine) {
+	if engine == GetEngine() {
+		return
+	}
+
+	engineStates.Delete(engine)
+}
+
+// getEngineState returns the state of an engine created with NewEngine, or nil
+// for engines created directly with wasmtime
+func getEngineState(engine *wasmtime.Engine) *engineState {
+	state, ok := engineStates.Load(engine)
+	if !ok {
+		return nil
+	}
+
+	return state.(*engineState)
+}
+
+// epochTicker increments the epoch of an engine while at least one call with a
+// deadline is in flight, the engine epoch is shared by every store so there is
+// a single ticker per engine
+type epochTicker struct {
+	engine   *wasmtime.Engine
+	interval time.Duration
+
+	mu   sync.Mutex
+	refs int
+	stop chan struct{}
+}
+
+func (t *epochTicker) acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refs++
+	if t.refs == 1 {
+		t.stop = make(chan struct{})
+		go t.loop(t.stop)
+	}
+}
+
+func (t *epochTicker) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refs--
+	if t.refs == 0 {
+		close(t.stop)
+	}
+}
+
+func (t *epochTicker) loop(stop chan struct{}) {
+	tick := time.NewTicker(t.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			t.engine.IncrementEpoch()
+		}
+	}
+}
+
+// deadlineTicks converts the time left until deadline into epoch ticks, rounding
+// up so a call is never interrupted early
+func (t *epochTicker) deadlineTicks(deadline time.Time) uint64 {
+	left := time.Until(deadline)
+	if left <= 0 {
+		return 0
+	}
+
+	return uint64(left/t.interval) + 1
+}
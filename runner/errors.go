@@ -0,0 +1,160 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+var (
+	// ErrPoolExhausted is returned by a fail-fast Pool when every instance is checked out
+	ErrPoolExhausted = errors.New("runner pool exhausted")
+
+	// ErrPoolClosed is returned when checking out an instance from a closed Pool
+	ErrPoolClosed = errors.New("runner pool closed")
+
+	// ErrPayloadTooLarge is returned when data does not fit in a managed I/O
+	// buffer, it is shared_types.ErrPayloadTooLarge
+	ErrPayloadTooLarge = shared_types.ErrPayloadTooLarge
+
+	// ErrOutOfBounds is returned when a guest pointer or length falls outside of guest memory
+	ErrOutOfBounds = errors.New("guest memory access out of bounds")
+
+	// ErrAllocFailed is returned when wasmy_alloc could not allocate a buffer
+	ErrAllocFailed = errors.New("guest failed to allocate buffer")
+
+	// ErrNoStreamOutput is sent to a guest that writes stream output outside of
+	// Runner.Stream, or when Stream was called without a writer
+	ErrNoStreamOutput = errors.New("call has no stream output")
+
+	// ErrReentrantCall is returned when a Runner is called while it is already
+	// running a call, host functions call back into the guest with a HostCallContext
+	ErrReentrantCall = errors.New("runner is already running a call")
+
+	// ErrCallDepthExceeded is returned by HostCallContext.Run when the calls nested
+	// through host functions go deeper than Runner.MaxCallDepth
+	ErrCallDepthExceeded = errors.New("guest call depth exceeded")
+
+	// ErrHostCallDone is returned when a HostCallContext is used after its host
+	// function returned
+	ErrHostCallDone = errors.New("host call context used after its host function returned")
+
+	// ErrABIVersion is returned by WarmUp for a module built against an ABI
+	// version the host can't run
+	ErrABIVersion = errors.New("unsupported ABI version")
+
+	// ErrCodecMismatch is returned by WarmUp when the guest doesn't speak any of
+	// the codecs the host offered
+	ErrCodecMismatch = errors.New("no codec in common with the guest")
+
+	// ErrDeadlineExceeded is returned when a guest call is interrupted because
+	// its context deadline passed, it also matches context.DeadlineExceeded
+	ErrDeadlineExceeded = fmt.Errorf("guest call interrupted: %w", context.DeadlineExceeded)
+
+	// ErrInstanceDiscarded is returned when calling into an instance that was
+	// discarded after an interrupted call, running out of fuel or going over
+	// its memory quota
+	ErrInstanceDiscarded = errors.New("instance discarded after interrupted call")
+
+	// ErrEpochDisabled is returned by RunContext when ctx has a deadline but the
+	// engine was not created with epoch interruption enabled
+	ErrEpochDisabled = errors.New("engine does not have epoch interruption enabled")
+
+	// ErrDuplicateHostFunc is returned when a HostModule function is defined twice
+	ErrDuplicateHostFunc = errors.New("host function already defined")
+
+	// ErrHostFuncConflict is returned when two host functions are linked as the same import
+	ErrHostFuncConflict = errors.New("conflicting host function imports")
+
+	// ErrMissingExport is reported by WarmUp for a boilerplate or requested export
+	// the module doesn't have
+	ErrMissingExport = errors.New("missing export")
+
+	// ErrExportSignature is reported by WarmUp for an export with the wrong type
+	ErrExportSignature = errors.New("export has the wrong signature")
+
+	// ErrUnresolvedImport is reported by WarmUp for an import no host function,
+	// host module or WASI defines
+	ErrUnresolvedImport = errors.New("unresolved import")
+
+	// ErrImportSignature is reported by WarmUp when a host function doesn't match
+	// the type the module imports it as
+	ErrImportSignature = errors.New("import has the wrong signature")
+
+	// ErrInvalidManifest is reported by WarmUp for a problem with Runner.Manifest itself
+	ErrInvalidManifest = errors.New("invalid manifest")
+
+	// ErrUndeclaredImport is reported by WarmUp for a host function the module
+	// imports but its manifest doesn't request, it is not linked
+	ErrUndeclaredImport = errors.New("import not requested by the manifest")
+
+	// ErrPermissionDenied is reported by WarmUp for an import Runner.Policy doesn't
+	// grant when it rejects them
+	ErrPermissionDenied = errors.New("host function not granted")
+
+	// ErrArgsMismatch is returned by Run when the arguments don't match the
+	// argument types of the export in Runner.Manifest
+	ErrArgsMismatch = errors.New("arguments don't match the manifest")
+
+	// ErrMemoryLimitExceeded is returned when a guest memory is found over its
+	// quota, after the call or host function call that grew it
+	ErrMemoryLimitExceeded = errors.New("guest memory limit exceeded")
+
+	// ErrFuelExhausted is returned when a guest call uses up its fuel budget
+	ErrFuelExhausted = errors.New("guest call exhausted its fuel budget")
+
+	// ErrFuelDisabled is returned by WarmUp when a FuelBudget is set but the
+	// engine was not created with fuel consumption enabled
+	ErrFuelDisabled = errors.New("engine does not have fuel consumption enabled")
+
+	// ErrWASIConflict is returned when a WasiConfig is passed to WarmUp or
+	// GetInstance while Runner.WASI is set, the WasiConfig would replace it
+	ErrWASIConflict = errors.New("both a WasiConfig and Runner.WASI are set")
+)
+
+// GuestError is returned by Run when the guest function reports an error in the
+// Payload error envelope, use errors.As to inspect it
+type GuestError struct {
+	// Function is the guest export that was called
+	Function string
+	Code     string
+	Message  string
+	Details  map[string]string
+}
+
+func (e *GuestError) Error() string {
+	return fmt.Sprintf("guest function %s failed: %s: %s", e.Function, e.Code, e.Message)
+}
+
+// newGuestError converts the Payload error envelope of a call to name
+func newGuestError(name string, err *shared_types.Error) *GuestError {
+	return &GuestError{
+		Function: name,
+		Code:     err.Code,
+		Message:  err.Message,
+		Details:  err.Details,
+	}
+}
+
+// contextErr maps a context error to the error returned by RunContext
+func contextErr(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrDeadlineExceeded
+	}
+
+	return err
+}
+
+// isInterrupt reports whether err is a trap raised by epoch interruption
+func isInterrupt(err error) bool {
+	var trap *wasmtime.Trap
+	if !errors.As(err, &trap) {
+		return false
+	}
+
+	code := trap.Code()
+	return code != nil && *code == wasmtime.Interrupt
+}
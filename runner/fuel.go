@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+// unlimitedFuel is the fuel a store is topped up to for calls without a budget
+// when fuel consumption is enabled, a store with no fuel traps immediately
+const unlimitedFuel = 1 << 62
+
+// fuelEnabled reports whether the runner engine was created with ConsumeFuel
+func (r *Runner) fuelEnabled() bool {
+	state := getEngineState(r.engine)
+	return state != nil && state.cfg.ConsumeFuel
+}
+
+// setFuel tops up or drains the store so that exactly budget fuel is left
+func (r *Runner) setFuel(budget uint64) error {
+	consumed, _ := r.store.FuelConsumed()
+	remaining := r.fuelAdded - consumed
+
+	if remaining < budget {
+		err := r.store.AddFuel(budget - remaining)
+		if err != nil {
+			return err
+		}
+
+		r.fuelAdded += budget - remaining
+		return nil
+	}
+
+	if remaining > budget {
+		_, err := r.store.ConsumeFuel(remaining - budget)
+		return err
+	}
+
+	return nil
+}
+
+// isOutOfFuel reports whether err is the trap raised when a store runs out of
+// fuel, wasmtime does not give it a trap code so the message is matched
+func isOutOfFuel(err error) bool {
+	var trap *wasmtime.Trap
+	if !errors.As(err, &trap) {
+		return false
+	}
+
+	return strings.HasPrefix(trap.Message(), "all fuel consumed")
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"fmt"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+const (
+	// legacyHostModule is the import module of Runner.HostFunctions, TinyGo puts
+	// functions declared without a body in `package main` there as `main.<name>`
+	legacyHostModule = "env"
+
+	// wasiModule is the import module of the WASI functions defined by the linker
+	wasiModule = "wasi_snapshot_preview1"
+)
+
+// HostModule is a named import module of host functions, for example a guest that
+// imports `wasmy:log/write` needs a HostModule named "wasmy:log" that defines a
+// "write" function. In the guest the import is declared with:
+//
+//	//go:wasmimport wasmy:log write
+//	func logWrite(int32) int32
+//
+// Imports declared with go:wasmimport are plain extern declarations, so TinyGo
+// imports them as (i32) -> i32 without its hidden context arguments.
+//
+// A HostModule only holds the unwrapped host functions, so it can be shared between
+// runners and pools, every instance wraps them with its own Runner.WrapExport.
+type HostModule struct {
+	Name  string
+	funcs map[string]ContextHostFunc
+	// order keeps linking deterministic
+	order []string
+}
+
+// NewHostModule creates an empty host module that the guest imports as name
+func NewHostModule(name string) *HostModule {
+	return &HostModule{
+		Name:  name,
+		funcs: make(map[string]ContextHostFunc),
+	}
+}
+
+// Define registers fn as the import `<module>/<name>`, registering the same
+// name twice returns ErrDuplicateHostFunc
+func (m *HostModule) Define(name string, fn HostFunc) error {
+	return m.DefineContext(name, func(_ *HostCallContext, args *shared_types.Args) (interface{}, error) {
+		return fn(args)
+	})
+}
+
+// DefineContext is Define for a host function that calls back into the guest
+// with a HostCallContext
+func (m *HostModule) DefineContext(name string, fn ContextHostFunc) error {
+	if _, ok := m.funcs[name]; ok {
+		return fmt.Errorf("%w: %s/%s", ErrDuplicateHostFunc, m.Name, name)
+	}
+
+	m.funcs[name] = fn
+	m.order = append(m.order, name)
+
+	return nil
+}
+
+// Funcs returns the names of the functions in the module in the order they
+// were defined
+func (m *HostModule) Funcs() []string {
+	return append([]string(nil), m.order...)
+}
+
+// hostImport is the `<module>/<name>` of a host function and where it came from,
+// used to report conflicts
+type hostImport struct {
+	module string
+	name   string
+	source string
+}
+
+func (h hostImport) String() string {
+	return h.module + "/" + h.name
+}
+
+// linkHostModules defines the functions of every HostModule, and of the built-in
+// `wasmy:stream` module, in the linker and returns every problem it ran into, an
+// import that is defined twice, either by two modules with the same name or by
+// clashing with HostFunctions or WASI, is an ErrHostFuncConflict
+func (r *Runner) linkHostModules(linker *wasmtime.Linker) []error {
+	var errs []error
+	seen := make(map[string]hostImport)
+	for name := range r.HostFunctions {
+		imp := hostImport{module: legacyHostModule, name: "main." + name, source: "Runner.HostFunctions"}
+		seen[imp.String()] = imp
+	}
+
+	for _, m := range append([]*HostModule{r.streamModule()}, r.HostModules...) {
+		if m.Name == wasiModule {
+			errs = append(errs, fmt.Errorf("%w: host module %q is reserved for WASI", ErrHostFuncConflict, m.Name))
+			continue
+		}
+
+		for _, name := range m.order {
+			if !r.linkable(m.Name, name) {
+				continue
+			}
+
+			imp := hostImport{module: m.Name, name: name, source: fmt.Sprintf("host module %q", m.Name)}
+			if prev, ok := seen[imp.String()]; ok {
+				errs = append(errs, fmt.Errorf("%w: %s is defined by both %s and %s", ErrHostFuncConflict, imp, prev.source, imp.source))
+				continue
+			}
+			seen[imp.String()] = imp
+
+			hostFn := m.funcs[name]
+			if stub := r.denied(m.Name, name); stub != nil {
+				hostFn = stub
+			}
+
+			fn := r.guard(r.WrapContextExport(hostFn))
+			err := linker.DefineFunc(r.store, m.Name, name, func(dataLen int32) (int32, *wasmtime.Trap) {
+				return fn(dataLen, 0, 0)
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to link host function %s: %w", imp, err))
+			}
+		}
+	}
+
+	return errs
+}
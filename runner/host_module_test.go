@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// testHostModuleWAT is the test guest with callHost importing `wasmy:test/echo`
+// instead of `env/main.echo`
+const testHostModuleWAT = `
+(module
+  (import "wasmy:test" "echo" (func $echo (param i32) (result i32)))
+  (memory (export "memory") 100)
+  (func (export "inputBuffer") (result i32) (i32.const %[1]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[2]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[4]d))
+  (func (export "callHost") (param $len i32) (result i32)
+    (local $n i32)
+    (memory.copy (i32.const %[3]d) (i32.const %[1]d) (local.get $len))
+    (local.set $n (call $echo (local.get $len)))
+    (memory.copy (i32.const %[2]d) (i32.const %[4]d) (local.get $n))
+    (local.get $n))
+)`
+
+func TestHostModule(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testHostModuleWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hm := NewHostModule("wasmy:test")
+	err = hm.Define("echo", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hm.Define("echo", echo)
+	if !errors.Is(err, ErrDuplicateHostFunc) {
+		t.Fatalf("expected ErrDuplicateHostFunc, got %v", err)
+	}
+
+	p, err := NewPool(engine, module, &PoolConfig{
+		Size:        1,
+		HostModules: []*HostModule{hm},
+		FuncNames:   []string{"callHost"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	out, err := p.Run(context.Background(), "callHost", "martin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != "martin" {
+		t.Fatalf("expected host echo, got %v", out.Data)
+	}
+
+	// the same import from two modules is a conflict
+	other := NewHostModule("wasmy:test")
+	other.Define("echo", func(*shared_types.Args) (interface{}, error) { return nil, nil })
+
+	r := &Runner{HostModules: []*HostModule{hm, other}}
+	err = r.WarmUp(engine, module, nil, "callHost")
+	if !errors.Is(err, ErrHostFuncConflict) {
+		t.Fatalf("expected ErrHostFuncConflict, got %v", err)
+	}
+
+	// and so is clashing with the legacy HostFunctions
+	legacy := NewHostModule("env")
+	legacy.Define("main.echo", echo)
+
+	r = &Runner{HostModules: []*HostModule{hm, legacy}}
+	r.HostFunctions = map[string]ExportFunc{"echo": r.WrapExport(echo)}
+	err = r.WarmUp(engine, module, nil, "callHost")
+	if !errors.Is(err, ErrHostFuncConflict) {
+		t.Fatalf("expected ErrHostFuncConflict, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package runner
+
+import (
+	"fmt"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	"github.com/lonelycode/wasmy/interfaces"
+)
+
+// bufferSizeExport returns the FUNCBUFFER_SIZE a fixed buffer module was built
+// with, modules with the allocator ABI don't have fixed buffers
+const bufferSizeExport = "wasmy_buffer_size"
+
+// Import providers reported by Inspect
+const (
+	ProviderWasi         = "wasi"
+	ProviderHostFunction = "host_function"
+	ProviderHostModule   = "host_module"
+	// ProviderBuiltin imports are linked by every Runner, e.g. `wasmy:stream`
+	ProviderBuiltin = "builtin"
+)
+
+// ModuleReport describes what a module exports and imports, it is built from the
+// module alone so nothing in it is run
+type ModuleReport struct {
+	Exports []ExportInfo `json:"exports"`
+	Imports []ImportInfo `json:"imports"`
+
+	// Boilerplate is true when the module carries every export module-params
+	// provides, MissingBoilerplate lists the ones it doesn't
+	Boilerplate        bool     `json:"boilerplate"`
+	MissingBoilerplate []string `json:"missing_boilerplate,omitempty"`
+
+	// Allocator is true when the module exports wasmy_alloc and wasmy_free, its
+	// buffers are then allocated for every call
+	Allocator bool `json:"allocator"`
+
+	// Codec is true when the module picks its codec through wasmy_codec, a module
+	// without it speaks msgpack
+	Codec bool `json:"codec"`
+
+	// BufferSize is the FUNCBUFFER_SIZE a fixed buffer module was built with,
+	// Inspect leaves it at 0, use ProbeBufferSize to fill it in
+	BufferSize int `json:"buffer_size,omitempty"`
+
+	// ABIVersion is the shared_types.ABIVersion the module was built against,
+	// Inspect leaves it at 0, use ProbeABIVersion to fill it in
+	ABIVersion int32 `json:"abi_version,omitempty"`
+}
+
+// ExportInfo is a single export of a module
+type ExportInfo struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Signature string `json:"signature"`
+
+	// Managed is true for functions that can be called with Runner.Run, i.e.
+	// (i32) -> (i32) functions that are not part of the boilerplate
+	Managed bool `json:"managed"`
+	// Boilerplate is true for the exports provided by module-params
+	Boilerplate bool `json:"boilerplate"`
+}
+
+// ImportInfo is a single import of a module
+type ImportInfo struct {
+	Module    string `json:"module"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Signature string `json:"signature"`
+
+	// Provider is what is expected to satisfy the import, one of the Provider
+	// constants
+	Provider string `json:"provider"`
+}
+
+// Inspect builds a report of the imports and exports of module and flags which
+// exports are wasmy-managed
+func Inspect(module *wasmtime.Module) *ModuleReport {
+	boilerplate := map[string]bool{"memory": true, bufferSizeExport: true, allocExport: true, freeExport: true, codecExport: true, abiVersionExport: true}
+	for _, name := range requiredBufferExports {
+		boilerplate[name] = true
+	}
+
+	report := &ModuleReport{
+		Exports: []ExportInfo{},
+		Imports: []ImportInfo{},
+	}
+
+	found := make(map[string]bool)
+	for _, exp := range module.Exports() {
+		kind, sig := externInfo(exp.Type())
+		info := ExportInfo{
+			Name:        exp.Name(),
+			Kind:        kind,
+			Signature:   sig,
+			Boilerplate: boilerplate[exp.Name()],
+		}
+
+		if fnType := exp.Type().FuncType(); fnType != nil && !info.Boilerplate {
+			managed := []wasmtime.ValKind{wasmtime.KindI32}
+			info.Managed = kindsEqual(fnType.Params(), managed) && kindsEqual(fnType.Results(), managed)
+		}
+
+		found[exp.Name()] = true
+		report.Exports = append(report.Exports, info)
+	}
+
+	for _, name := range append([]string{"memory"}, requiredBufferExports...) {
+		if !found[name] {
+			report.MissingBoilerplate = append(report.MissingBoilerplate, name)
+		}
+	}
+	report.Boilerplate = len(report.MissingBoilerplate) == 0
+	report.Allocator = found[allocExport] && found[freeExport]
+	report.Codec = found[codecExport]
+
+	for _, imp := range module.Imports() {
+		name := ""
+		if imp.Name() != nil {
+			name = *imp.Name()
+		}
+
+		kind, sig := externInfo(imp.Type())
+		info := ImportInfo{
+			Module:    imp.Module(),
+			Name:      name,
+			Kind:      kind,
+			Signature: sig,
+			Provider:  ProviderHostModule,
+		}
+
+		switch imp.Module() {
+		case wasiModule:
+			info.Provider = ProviderWasi
+		case legacyHostModule:
+			info.Provider = ProviderHostFunction
+		case interfaces.StreamModule:
+			info.Provider = ProviderBuiltin
+		}
+
+		report.Imports = append(report.Imports, info)
+	}
+
+	return report
+}
+
+// ProbeBufferSize instantiates module in a throwaway store and calls its
+// wasmy_buffer_size export. Every import other than WASI is stubbed out to return
+// zeros, so a module that calls the host while it is being instantiated may
+// misbehave. Modules without the export return 0.
+func ProbeBufferSize(engine *wasmtime.Engine, module *wasmtime.Module) (int, error) {
+	size, err := probeExport(engine, module, bufferSizeExport)
+	return int(size), err
+}
+
+// ProbeABIVersion is ProbeBufferSize for the wasmy_abi_version export, modules
+// built before the export existed return 0
+func ProbeABIVersion(engine *wasmtime.Engine, module *wasmtime.Module) (int32, error) {
+	return probeExport(engine, module, abiVersionExport)
+}
+
+// probeExport calls the () -> i32 export name of module in a throwaway store, it
+// returns 0 if the module doesn't have it
+func probeExport(engine *wasmtime.Engine, module *wasmtime.Module, name string) (int32, error) {
+	found := false
+	for _, exp := range module.Exports() {
+		if exp.Name() == name {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return 0, nil
+	}
+
+	store := wasmtime.NewStore(engine)
+	store.SetEpochDeadline(noEpochDeadline)
+	store.SetWasi(wasmtime.NewWasiConfig())
+
+	if state := getEngineState(engine); state != nil && state.cfg.ConsumeFuel {
+		err := store.AddFuel(unlimitedFuel)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	linker := wasmtime.NewLinker(engine)
+	err := linker.DefineWasi()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, imp := range module.Imports() {
+		fnType := imp.Type().FuncType()
+		if imp.Module() == wasiModule || fnType == nil || imp.Name() == nil {
+			continue
+		}
+
+		results := fnType.Results()
+		err := linker.FuncNew(imp.Module(), *imp.Name(), fnType, func(*wasmtime.Caller, []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+			out := make([]wasmtime.Val, len(results))
+			for i, ty := range results {
+				out[i] = zeroVal(ty.Kind())
+			}
+			return out, nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	instance, err := linker.Instantiate(store, module)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := instance.GetFunc(store, name).Call(store)
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := res.(int32)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s returned %T, want int32", ErrExportSignature, name, res)
+	}
+
+	return n, nil
+}
+
+// externInfo returns the kind of an import or export and a readable signature
+func externInfo(ty *wasmtime.ExternType) (string, string) {
+	switch {
+	case ty.FuncType() != nil:
+		fnType := ty.FuncType()
+		return "func", funcSig(fnType.Params(), fnType.Results())
+	case ty.MemoryType() != nil:
+		memType := ty.MemoryType()
+		if ok, max := memType.Maximum(); ok {
+			return "memory", fmt.Sprintf("pages %d..%d", memType.Minimum(), max)
+		}
+		return "memory", fmt.Sprintf("pages %d..", memType.Minimum())
+	case ty.GlobalType() != nil:
+		globalType := ty.GlobalType()
+		if globalType.Mutable() {
+			return "global", "mut " + globalType.Content().Kind().String()
+		}
+		return "global", globalType.Content().Kind().String()
+	case ty.TableType() != nil:
+		return "table", ty.TableType().Element().Kind().String()
+	}
+
+	return "unknown", ""
+}
+
+func zeroVal(kind wasmtime.ValKind) wasmtime.Val {
+	switch kind {
+	case wasmtime.KindI64:
+		return wasmtime.ValI64(0)
+	case wasmtime.KindF32:
+		return wasmtime.ValF32(0)
+	case wasmtime.KindF64:
+		return wasmtime.ValF64(0)
+	}
+
+	return wasmtime.ValI32(0)
+}
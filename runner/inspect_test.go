@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+func TestInspect(t *testing.T) {
+	engine := NewEngine(nil)
+	report := Inspect(testModule(t, engine, &shared_types.Payload{Data: "ok"}))
+
+	if !report.Boilerplate || len(report.MissingBoilerplate) != 0 {
+		t.Errorf("expected boilerplate, missing %v", report.MissingBoilerplate)
+	}
+
+	exports := make(map[string]ExportInfo)
+	for _, exp := range report.Exports {
+		exports[exp.Name] = exp
+	}
+
+	if exp := exports["callHost"]; !exp.Managed || exp.Boilerplate || exp.Signature != "(i32) -> (i32)" {
+		t.Errorf("unexpected callHost export: %+v", exp)
+	}
+
+	if exp := exports["inputBuffer"]; exp.Managed || !exp.Boilerplate {
+		t.Errorf("unexpected inputBuffer export: %+v", exp)
+	}
+
+	if exp := exports["memory"]; exp.Kind != "memory" || exp.Signature != "pages 100.." {
+		t.Errorf("unexpected memory export: %+v", exp)
+	}
+
+	if report.Allocator {
+		t.Errorf("expected fixed buffers")
+	}
+
+	wasm, err := wasmtime.Wat2Wasm(testAllocGuestWAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alloc := Inspect(module); !alloc.Allocator || !alloc.Boilerplate {
+		t.Errorf("expected the allocator ABI, got %+v", alloc)
+	}
+
+	if len(report.Imports) != 1 {
+		t.Fatalf("expected 1 import, got %+v", report.Imports)
+	}
+
+	imp := report.Imports[0]
+	if imp.Module != "env" || imp.Name != "main.echo" || imp.Provider != ProviderHostFunction {
+		t.Errorf("unexpected import: %+v", imp)
+	}
+}
+
+func TestInspectMissingBoilerplate(t *testing.T) {
+	engine := NewEngine(nil)
+	wasm, err := wasmtime.Wat2Wasm(`(module (func (export "run") (param i32) (result i32) (local.get 0)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Inspect(module)
+	if report.Boilerplate || len(report.MissingBoilerplate) != 5 {
+		t.Errorf("expected the boilerplate to be missing, got %v", report.MissingBoilerplate)
+	}
+
+	if !report.Exports[0].Managed {
+		t.Errorf("expected run to be managed")
+	}
+}
+
+func TestProbeBufferSize(t *testing.T) {
+	engine := NewEngine(&EngineConfig{EpochInterruption: true, ConsumeFuel: true})
+	wasm, err := wasmtime.Wat2Wasm(`
+(module
+  (import "wasmy:test" "get" (func $get (param i32) (result i32)))
+  (func (export "wasmy_buffer_size") (result i32)
+    (i32.add (call $get (i32.const 1)) (i32.const 4096))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := ProbeBufferSize(engine, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 4096 {
+		t.Errorf("expected 4096, got %d", size)
+	}
+
+	size, err = ProbeBufferSize(engine, testModule(t, engine, &shared_types.Payload{}))
+	if err != nil || size != 0 {
+		t.Errorf("expected 0 for a module without the export, got %d, %v", size, err)
+	}
+}
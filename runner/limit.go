@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+// wasm binary layout
+const (
+	wasmHeaderSize    = 8
+	wasmMemorySection = 5
+
+	// limitsHasMax is the flag of a memory type that declares a maximum
+	limitsHasMax = 0x01
+	// limitsMemory64 is the flag of a 64 bit memory
+	limitsMemory64 = 0x04
+)
+
+var errBadWasm = errors.New("malformed wasm binary")
+
+// LimitMemory caps the maximum of every memory wasm defines at maxPages, so a
+// guest can't grow its memory past that: memory.grow fails and returns -1, which
+// allocators turn into a trap. The wasmtime-go v1 bindings this module builds
+// against have no store limiter, so the cap has to be in the module itself.
+//
+// Memories that declare a smaller maximum keep it, a memory that starts out
+// bigger than maxPages is an ErrMemoryLimitExceeded.
+func LimitMemory(wasm []byte, maxPages uint64) ([]byte, error) {
+	if len(wasm) < wasmHeaderSize || !bytes.Equal(wasm[:4], []byte("\x00asm")) {
+		return nil, errBadWasm
+	}
+
+	out := append([]byte{}, wasm[:wasmHeaderSize]...)
+	found := false
+
+	for pos := wasmHeaderSize; pos < len(wasm); {
+		id := wasm[pos]
+		size, n := binary.Uvarint(wasm[pos+1:])
+		start := pos + 1 + n
+		if n <= 0 || size > uint64(len(wasm)-start) {
+			return nil, errBadWasm
+		}
+		end := start + int(size)
+
+		if id != wasmMemorySection {
+			out = append(out, wasm[pos:end]...)
+			pos = end
+			continue
+		}
+
+		content, err := limitMemories(wasm[start:end], maxPages)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, id)
+		out = appendUvarint(out, uint64(len(content)))
+		out = append(out, content...)
+		found = true
+		pos = end
+	}
+
+	if !found {
+		return nil, fmt.Errorf("module doesn't define a memory to limit")
+	}
+
+	return out, nil
+}
+
+// limitMemories rewrites the memory types of a memory section
+func limitMemories(section []byte, maxPages uint64) ([]byte, error) {
+	r := bytes.NewReader(section)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errBadWasm
+	}
+
+	out := appendUvarint(nil, count)
+	for i := uint64(0); i < count; i++ {
+		flags, err := r.ReadByte()
+		if err != nil || flags&limitsMemory64 != 0 {
+			return nil, fmt.Errorf("%w: unsupported memory type", errBadWasm)
+		}
+
+		initial, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errBadWasm
+		}
+
+		limit := maxPages
+		if flags&limitsHasMax != 0 {
+			declared, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errBadWasm
+			}
+
+			if declared < limit {
+				limit = declared
+			}
+		}
+
+		if initial > limit {
+			return nil, fmt.Errorf("%w: memory %d starts at %d pages, quota is %d pages", ErrMemoryLimitExceeded, i, initial, maxPages)
+		}
+
+		out = append(out, flags|limitsHasMax)
+		out = appendUvarint(out, initial)
+		out = appendUvarint(out, limit)
+	}
+
+	if r.Len() != 0 {
+		return nil, errBadWasm
+	}
+
+	return out, nil
+}
+
+// CompileModule compiles wasm for instances that use cfg, with its memory capped
+// at cfg.MaxPages (see LimitMemory) if there is a quota
+func CompileModule(engine *wasmtime.Engine, wasm []byte, cfg *RunnerConfig) (*wasmtime.Module, error) {
+	if cfg != nil && cfg.MaxPages > 0 {
+		var err error
+		wasm, err = LimitMemory(wasm, cfg.MaxPages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return wasmtime.NewModule(engine, wasm)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
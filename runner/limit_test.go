@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+func TestLimitMemory(t *testing.T) {
+	engine := GetEngine()
+	wasm := testWasm(t, &shared_types.Payload{Data: "constant"})
+
+	cfg := &RunnerConfig{MaxPages: 105}
+	module, err := CompileModule(engine, wasm, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range module.Exports() {
+		if mem := exp.Type().MemoryType(); mem != nil {
+			if ok, max := mem.Maximum(); !ok || max != 105 {
+				t.Fatalf("expected a maximum of 105 pages, got %v %d", ok, max)
+			}
+		}
+	}
+
+	r := &Runner{Config: cfg}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err = r.WarmUp(engine, module, nil, "grow", "growOrTrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// growing past the quota fails inside the call, the guest traps on it
+	_, err = r.Run("growOrTrap", "x")
+	var trap *wasmtime.Trap
+	if !errors.As(err, &trap) {
+		t.Fatalf("expected a trap, got %v", err)
+	}
+
+	if pages := r.MemoryUsage().Pages; pages != 100 {
+		t.Fatalf("expected memory to stay at 100 pages, got %d", pages)
+	}
+
+	// a guest that ignores the failed grow just doesn't get the memory
+	_, err = r.Run("grow", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LimitMemory(wasm, 50)
+	if !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+
+	_, err = LimitMemory([]byte("not wasm"), 50)
+	if err == nil {
+		t.Fatal("expected an error for a malformed module")
+	}
+}
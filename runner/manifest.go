@@ -0,0 +1,407 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	"github.com/lonelycode/wasmy/interfaces"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFiles are the names FindManifest looks for next to a module, in order
+var ManifestFiles = []string{"wasmy.yaml", "wasmy.yml", "wasmy.json"}
+
+// Manifest describes a plugin: what it exports, which host functions it needs and
+// what WASI access it asks for. Set it as Runner.Manifest and WarmUp validates the
+// module against it and only links the host functions it requests.
+//
+//	name: greeter
+//	version: 1.2.0
+//	exports:
+//	  - name: hello
+//	    args:
+//	      - {name: who, type: string}
+//	    returns: string
+//	imports:
+//	  - echo            # Runner.HostFunctions["echo"]
+//	  - wasmy:log/write # a HostModule function
+//	wasi:
+//	  dirs:
+//	    - {path: /data, access: read-only}
+//	  env: [LANG]
+type Manifest struct {
+	Name        string           `json:"name" yaml:"name"`
+	Version     string           `json:"version" yaml:"version"`
+	Description string           `json:"description,omitempty" yaml:"description,omitempty"`
+	Exports     []ManifestExport `json:"exports,omitempty" yaml:"exports,omitempty"`
+	// Imports are the host functions the plugin needs as `module/name`, a name
+	// without a module is a function in Runner.HostFunctions. WASI and built-in
+	// imports like `wasmy:stream` don't have to be listed.
+	Imports []string      `json:"imports,omitempty" yaml:"imports,omitempty"`
+	WASI    *ManifestWASI `json:"wasi,omitempty" yaml:"wasi,omitempty"`
+}
+
+// ManifestExport is a guest function that can be called with Runner.Run
+type ManifestExport struct {
+	Name        string        `json:"name" yaml:"name"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Args        []ManifestArg `json:"args,omitempty" yaml:"args,omitempty"`
+	// Returns is the type of Payload.Data, it is documentation only
+	Returns string `json:"returns,omitempty" yaml:"returns,omitempty"`
+}
+
+// ManifestArg is an argument of an export, Type is one of ArgTypes
+type ManifestArg struct {
+	Name string `json:"name" yaml:"name"`
+	Type string `json:"type" yaml:"type"`
+}
+
+// ArgTypes are the argument types a manifest can use
+var ArgTypes = []string{"any", "string", "int", "float", "bool", "bytes", "time", "array", "map"}
+
+// ManifestWASI is the WASI access a plugin asks for
+type ManifestWASI struct {
+	Dirs []ManifestDir `json:"dirs,omitempty" yaml:"dirs,omitempty"`
+	// Env are the host environment variables the plugin reads
+	Env   []string `json:"env,omitempty" yaml:"env,omitempty"`
+	Args  bool     `json:"args,omitempty" yaml:"args,omitempty"`
+	Stdin bool     `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+}
+
+// ManifestDir is a dir a plugin wants to see, Access is "read-only" (the default)
+// or "read-write"
+type ManifestDir struct {
+	Path   string `json:"path" yaml:"path"`
+	Access string `json:"access,omitempty" yaml:"access,omitempty"`
+}
+
+// LoadManifest reads a manifest, files ending in .json are decoded as JSON and
+// everything else as YAML. Unknown fields are an error, so typos don't go
+// unnoticed.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := ParseManifest(data, strings.EqualFold(filepath.Ext(path), ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// FindManifest loads the manifest next to the module at modulePath, the error
+// matches os.ErrNotExist when there is none
+func FindManifest(modulePath string) (*Manifest, error) {
+	dir := filepath.Dir(modulePath)
+	for _, name := range ManifestFiles {
+		m, err := LoadManifest(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		return m, err
+	}
+
+	return nil, fmt.Errorf("no manifest next to %s: %w", modulePath, os.ErrNotExist)
+}
+
+// ParseManifest decodes a JSON or YAML manifest
+func ParseManifest(data []byte, isJSON bool) (*Manifest, error) {
+	m := &Manifest{}
+
+	var err error
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(m)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(m)
+
+		// an empty document is an empty manifest, check reports what it lacks
+		if err == io.EOF {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// Validate checks the manifest itself and that module exports every function it
+// lists and imports no host function it doesn't request, problems are returned
+// as a *ValidationError
+func (m *Manifest) Validate(module *wasmtime.Module) error {
+	return validationErr(m.validate(module))
+}
+
+func (m *Manifest) validate(module *wasmtime.Module) []error {
+	errs := m.check()
+
+	exports := make(map[string]*wasmtime.ExternType)
+	for _, exp := range module.Exports() {
+		exports[exp.Name()] = exp.Type()
+	}
+
+	for _, exp := range m.Exports {
+		errs = append(errs, checkFuncExport(exports, exp.Name, []wasmtime.ValKind{wasmtime.KindI32}, []wasmtime.ValKind{wasmtime.KindI32})...)
+	}
+
+	return append(errs, m.importErrs(module)...)
+}
+
+// importErrs checks that module imports no host function the manifest doesn't
+// request
+func (m *Manifest) importErrs(module *wasmtime.Module) []error {
+	var errs []error
+	for _, imp := range module.Imports() {
+		name := ""
+		if imp.Name() != nil {
+			name = *imp.Name()
+		}
+
+		if imp.Type().FuncType() != nil && !m.Requests(imp.Module(), name) {
+			errs = append(errs, fmt.Errorf("%w: %s/%s", ErrUndeclaredImport, imp.Module(), name))
+		}
+	}
+
+	return errs
+}
+
+// manifestErrs validates the module against Runner.Manifest, if there is one.
+// The exports it lists are left to WarmUp, which checks them along with the
+// other functions it warms up.
+func (r *Runner) manifestErrs(module *wasmtime.Module) []error {
+	if r.Manifest == nil {
+		return nil
+	}
+
+	return append(r.Manifest.check(), r.Manifest.importErrs(module)...)
+}
+
+// check validates the manifest on its own
+func (m *Manifest) check() []error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrInvalidManifest, fmt.Sprintf(format, args...)))
+	}
+
+	if m.Name == "" {
+		invalid("name is missing")
+	}
+
+	if m.Version == "" {
+		invalid("version is missing")
+	}
+
+	seen := make(map[string]bool)
+	for _, exp := range m.Exports {
+		if exp.Name == "" {
+			invalid("export without a name")
+		} else if seen[exp.Name] {
+			invalid("export %s is listed twice", exp.Name)
+		}
+		seen[exp.Name] = true
+
+		for i, arg := range exp.Args {
+			if !validArgType(arg.Type) {
+				invalid("argument %d of %s has unknown type %q, want one of %s", i, exp.Name, arg.Type, strings.Join(ArgTypes, ", "))
+			}
+		}
+	}
+
+	for _, imp := range m.Imports {
+		if mod, name := splitImport(imp); mod == "" || name == "" {
+			invalid("import %q is not `module/name`", imp)
+		}
+	}
+
+	if m.WASI != nil {
+		for _, dir := range m.WASI.Dirs {
+			if dir.Path == "" {
+				invalid("wasi dir without a path")
+			}
+
+			if _, err := dir.access(); err != nil {
+				invalid("%v", err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// Requests reports whether the manifest asks for the host function module/name,
+// WASI and built-in imports are always allowed
+func (m *Manifest) Requests(module, name string) bool {
+	if !needsRequest(module) {
+		return true
+	}
+
+	for _, imp := range m.Imports {
+		mod, n := splitImport(imp)
+		if mod == module && n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// needsRequest reports whether imports from module have to be requested
+func needsRequest(module string) bool {
+	return module != wasiModule && module != interfaces.StreamModule
+}
+
+// Export returns the export called name
+func (m *Manifest) Export(name string) (*ManifestExport, bool) {
+	for i := range m.Exports {
+		if m.Exports[i].Name == name {
+			return &m.Exports[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// WASIOptions grants the WASI access the manifest asks for: every dir at the same
+// path on the host, the listed environment variables of the host, and the host
+// args and stdin if requested. Only use it for manifests you trust.
+func (m *Manifest) WASIOptions() (*WASIOptions, error) {
+	opts := Sandboxed()
+	if m.WASI == nil {
+		return opts, nil
+	}
+
+	for _, dir := range m.WASI.Dirs {
+		access, err := dir.access()
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Dir(dir.Path, dir.Path, access)
+	}
+
+	opts.AllowEnv(m.WASI.Env...)
+	if m.WASI.Args {
+		opts.InheritArgs()
+	}
+
+	if m.WASI.Stdin {
+		opts.InheritStdin()
+	}
+
+	return opts, nil
+}
+
+func (d ManifestDir) access() (DirAccess, error) {
+	switch d.Access {
+	case "", "read-only":
+		return ReadOnly, nil
+	case "read-write":
+		return ReadWrite, nil
+	}
+
+	return 0, fmt.Errorf("wasi dir %s has unknown access %q, want read-only or read-write", d.Path, d.Access)
+}
+
+// CheckArgs checks args against the argument types of the export, an export the
+// manifest doesn't list takes anything
+func (m *Manifest) CheckArgs(name string, args []interface{}) error {
+	exp, ok := m.Export(name)
+	if !ok {
+		return nil
+	}
+
+	if len(args) != len(exp.Args) {
+		return fmt.Errorf("%w: %s takes %d arguments, got %d", ErrArgsMismatch, name, len(exp.Args), len(args))
+	}
+
+	for i, arg := range exp.Args {
+		if !argTypeMatches(arg.Type, args[i]) {
+			return fmt.Errorf("%w: argument %d (%s) of %s must be a %s, got %T", ErrArgsMismatch, i, arg.Name, name, arg.Type, args[i])
+		}
+	}
+
+	return nil
+}
+
+// splitImport splits `module/name`, a name without a module is a function in
+// Runner.HostFunctions
+func splitImport(imp string) (string, string) {
+	i := strings.LastIndex(imp, "/")
+	if i < 0 {
+		return legacyHostModule, "main." + imp
+	}
+
+	return imp[:i], imp[i+1:]
+}
+
+func validArgType(t string) bool {
+	for _, known := range ArgTypes {
+		if t == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func argTypeMatches(t string, v interface{}) bool {
+	if t == "any" {
+		return true
+	}
+
+	if v == nil {
+		return false
+	}
+
+	rv := reflect.ValueOf(v)
+	switch t {
+	case "string":
+		return rv.Kind() == reflect.String
+	case "int":
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+	case "float":
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return true
+		}
+		return argTypeMatches("int", v)
+	case "bool":
+		return rv.Kind() == reflect.Bool
+	case "bytes":
+		_, ok := v.([]byte)
+		return ok
+	case "time":
+		_, ok := v.(time.Time)
+		return ok
+	case "array":
+		_, isBytes := v.([]byte)
+		return !isBytes && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array)
+	case "map":
+		return rv.Kind() == reflect.Map
+	}
+
+	return false
+}
@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+const testManifestYAML = `
+name: test-guest
+version: 0.1.0
+exports:
+  - name: constant
+    returns: string
+  - name: callHost
+    args:
+      - {name: value, type: any}
+imports:
+  - echo
+wasi:
+  dirs:
+    - path: /data
+  env: [LANG]
+`
+
+const testManifestJSON = `{
+  "name": "test-guest",
+  "version": "0.1.0",
+  "exports": [
+    {"name": "constant", "returns": "string"},
+    {"name": "callHost", "args": [{"name": "value", "type": "any"}]}
+  ],
+  "imports": ["echo"],
+  "wasi": {"dirs": [{"path": "/data"}], "env": ["LANG"]}
+}`
+
+func TestParseManifest(t *testing.T) {
+	fromYAML, err := ParseManifest([]byte(testManifestYAML), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromJSON, err := ParseManifest([]byte(testManifestJSON), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Fatalf("YAML and JSON differ:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+
+	_, err = ParseManifest([]byte("name: x\nversion: 1\nexport: []"), false)
+	if err == nil || !strings.Contains(err.Error(), "export") {
+		t.Fatalf("expected an error for an unknown field, got %v", err)
+	}
+
+	// numbers are kept as written
+	m, err := ParseManifest([]byte("name: x\nversion: 1.10"), false)
+	if err != nil || m.Version != "1.10" {
+		t.Fatalf("expected version 1.10, got %+v, %v", m, err)
+	}
+
+	dir := t.TempDir()
+	_, err = FindManifest(filepath.Join(dir, "plugin.wasm"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "wasmy.json"), []byte(testManifestJSON), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = FindManifest(filepath.Join(dir, "plugin.wasm"))
+	if err != nil || m.Name != "test-guest" {
+		t.Fatalf("unexpected manifest %+v, %v", m, err)
+	}
+}
+
+func TestRunnerManifest(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "ok"})
+
+	manifest, err := ParseManifest([]byte(testManifestYAML), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{Manifest: manifest}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	// the exports in the manifest are warmed up without being named
+	err = r.WarmUp(engine, module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := r.Run("callHost", "hi")
+	if err != nil || out.Data != "hi" {
+		t.Fatalf("unexpected output %+v, %v", out, err)
+	}
+
+	_, err = r.Run("callHost")
+	if !errors.Is(err, ErrArgsMismatch) {
+		t.Fatalf("expected ErrArgsMismatch, got %v", err)
+	}
+
+	// echo is defined but not requested, so it isn't linked
+	manifest.Imports = nil
+	r = &Runner{Manifest: manifest}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err = r.WarmUp(engine, module, nil)
+	if !errors.Is(err, ErrUndeclaredImport) || errors.Is(err, ErrUnresolvedImport) {
+		t.Fatalf("expected only ErrUndeclaredImport, got %v", err)
+	}
+
+	// a missing export is reported once, whether or not it is also named
+	manifest.Imports = []string{"echo"}
+	manifest.Exports = append(manifest.Exports, ManifestExport{Name: "missing"})
+	r = &Runner{Manifest: manifest}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err = r.WarmUp(engine, module, nil, "missing")
+	vErr := &ValidationError{}
+	if !errors.As(err, &vErr) || len(vErr.Errs) != 1 || !errors.Is(err, ErrMissingExport) {
+		t.Fatalf("expected a single ErrMissingExport, got %v", err)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "ok"})
+
+	m := &Manifest{
+		Exports: []ManifestExport{
+			{Name: "constant", Args: []ManifestArg{{Name: "x", Type: "number"}}},
+			{Name: "missing"},
+		},
+		Imports: []string{"echo"},
+		WASI:    &ManifestWASI{Dirs: []ManifestDir{{Path: "/data", Access: "write"}}},
+	}
+
+	err := m.Validate(module)
+	vErr := &ValidationError{}
+	if !errors.As(err, &vErr) {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+
+	// name, version, the arg type and the dir access
+	invalid := 0
+	for _, e := range vErr.Errs {
+		if errors.Is(e, ErrInvalidManifest) {
+			invalid++
+		}
+	}
+
+	if invalid != 4 || !errors.Is(err, ErrMissingExport) || len(vErr.Errs) != 5 {
+		t.Fatalf("unexpected errors %v", err)
+	}
+}
+
+func TestManifestCheckArgs(t *testing.T) {
+	m := &Manifest{Exports: []ManifestExport{{Name: "f", Args: []ManifestArg{
+		{Name: "s", Type: "string"},
+		{Name: "n", Type: "float"},
+		{Name: "b", Type: "bytes"},
+		{Name: "l", Type: "array"},
+	}}}}
+
+	err := m.CheckArgs("f", []interface{}{"x", 1, []byte("y"), []string{"z"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.CheckArgs("f", []interface{}{"x", 1, []byte("y"), []byte("z")})
+	if !errors.Is(err, ErrArgsMismatch) {
+		t.Fatalf("expected ErrArgsMismatch for bytes as an array, got %v", err)
+	}
+
+	if err := m.CheckArgs("other", []interface{}{1}); err != nil {
+		t.Fatalf("expected unlisted exports to take anything, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"fmt"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	"github.com/lonelycode/wasmy/interfaces"
+)
+
+// GuestBuffers are the boilerplate exports used to move data in and out of a guest.
+//
+// Modules built with fixed size buffers return a pointer to a FUNCBUFFER_SIZE
+// array from each buffer export. Modules that also export the allocator ABI
+// (`wasmy_alloc(size i32) -> i32` and `wasmy_free(ptr i32)`) get a buffer of
+// exactly the encoded size for every call instead:
+//
+//   - data sent to the guest (export args, host function results) is written to a
+//     buffer the host allocates with wasmy_alloc, the guest takes ownership of it
+//     when it is called or the host function returns
+//   - data sent to the host is read from the pointer the outputBuffer and
+//     hostInputBuffer exports return after the guest wrote it
+//   - the host only calls wasmy_free for a buffer it allocated but could not hand
+//     over to the guest
+//
+// Alloc and Free are nil for fixed size modules.
+type GuestBuffers struct {
+	Input      *wasmtime.Func
+	Output     *wasmtime.Func
+	HostInput  *wasmtime.Func
+	HostOutput *wasmtime.Func
+
+	Alloc *wasmtime.Func
+	Free  *wasmtime.Func
+
+	// LegacyEnvelopes is set for ABIv1 guests, they are sent an encoded envelope
+	// for every call instead of a length of 0 for no args or a nil result
+	LegacyEnvelopes bool
+}
+
+// Dynamic reports whether the guest exports the allocator ABI
+func (b *GuestBuffers) Dynamic() bool {
+	return b.Alloc != nil && b.Free != nil
+}
+
+// memory returns bounds checked access to mem, fixed size buffers also limit the
+// size of every read and write
+func (b *GuestBuffers) memory(store wasmtime.Storelike, mem *wasmtime.Memory) guestMemory {
+	g := guestMemory{store: store, mem: mem}
+	if !b.Dynamic() {
+		g.limit = interfaces.FUNCBUFFER_SIZE
+	}
+
+	return g
+}
+
+// put copies data into the guest, either into the fixed buffer returned by fixed
+// or into a buffer allocated for it, and returns the number of bytes written
+func (b *GuestBuffers) put(store wasmtime.Storelike, mem *wasmtime.Memory, fixed *wasmtime.Func, data []byte) (int32, error) {
+	gMem := b.memory(store, mem)
+
+	if !b.Dynamic() {
+		ptr, err := bufferPtr(store, fixed)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := gMem.write(ptr, data)
+		return int32(n), err
+	}
+
+	if int64(len(data)) > 1<<31-1 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(data))
+	}
+
+	ret, err := b.Alloc.Call(store, int32(len(data)))
+	if err != nil {
+		return 0, err
+	}
+
+	ptr, ok := ret.(int32)
+	if !ok || ptr == 0 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrAllocFailed, len(data))
+	}
+
+	n, err := gMem.write(ptr, data)
+	if err != nil {
+		// the guest never saw the buffer, so it is still ours to free
+		_, fErr := b.Free.Call(store, ptr)
+		if fErr != nil {
+			return 0, fErr
+		}
+
+		return 0, err
+	}
+
+	return int32(n), nil
+}
+
+// get reads length bytes from the buffer the guest reports through fn
+func (b *GuestBuffers) get(store wasmtime.Storelike, mem *wasmtime.Memory, fn *wasmtime.Func, length int32) ([]byte, error) {
+	ptr, err := bufferPtr(store, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if ptr == 0 && b.Dynamic() {
+		return nil, fmt.Errorf("%w: guest reported %d bytes without a buffer", ErrOutOfBounds, length)
+	}
+
+	return b.memory(store, mem).read(ptr, length)
+}
+
+// guestMemory provides bounds checked access to the linear memory of a guest,
+// all host reads and writes of the managed I/O buffers must go through it so
+// a bad pointer or length from the guest can never corrupt memory or panic
+// the host
+type guestMemory struct {
+	store wasmtime.Storelike
+	mem   *wasmtime.Memory
+	// limit is the size of a fixed buffer, 0 for allocated buffers
+	limit int
+}
+
+// check validates that [ptr, ptr+length) fits in a managed buffer and in the
+// guest memory
+func (g guestMemory) check(ptr int32, length int) error {
+	if g.limit > 0 && length > g.limit {
+		return fmt.Errorf("%w: %d bytes exceeds the %d byte buffer", ErrPayloadTooLarge, length, g.limit)
+	}
+
+	if ptr < 0 || length < 0 {
+		return fmt.Errorf("%w: pointer %d, length %d", ErrOutOfBounds, ptr, length)
+	}
+
+	size := uint64(g.mem.DataSize(g.store))
+	if uint64(ptr)+uint64(length) > size {
+		return fmt.Errorf("%w: %d bytes at %d overflows %d bytes of guest memory", ErrOutOfBounds, length, ptr, size)
+	}
+
+	return nil
+}
+
+// read copies length bytes from ptr out of guest memory
+func (g guestMemory) read(ptr int32, length int32) ([]byte, error) {
+	err := g.check(ptr, int(length))
+	if err != nil {
+		return nil, err
+	}
+
+	dat := make([]byte, length)
+	copy(dat, g.mem.UnsafeData(g.store)[ptr:int(ptr)+int(length)])
+
+	return dat, nil
+}
+
+// write copies data into guest memory at ptr and returns the number of bytes written
+func (g guestMemory) write(ptr int32, data []byte) (int, error) {
+	err := g.check(ptr, len(data))
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(g.mem.UnsafeData(g.store)[ptr:int(ptr)+len(data)], data), nil
+}
+
+// bufferPtr calls one of the boilerplate buffer exports and returns the buffer
+// pointer it reports
+func bufferPtr(store wasmtime.Storelike, fn *wasmtime.Func) (int32, error) {
+	ret, err := fn.Call(store)
+	if err != nil {
+		return 0, err
+	}
+
+	ptr, ok := ret.(int32)
+	if !ok {
+		return 0, fmt.Errorf("%w: buffer export returned %T", ErrOutOfBounds, ret)
+	}
+
+	return ptr, nil
+}
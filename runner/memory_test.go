@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	"github.com/lonelycode/wasmy/interfaces"
+)
+
+// testAllocGuestWAT is a guest with the allocator ABI, wasmy_alloc is a bump
+// allocator that remembers the last buffer it handed out the way the module-params
+// boilerplate does:
+//   - callHost: passes its input to the `echo` host function and returns the host output
+//   - frees: counts the calls to wasmy_free
+const testAllocGuestWAT = `
+(module
+  (import "env" "main.echo" (func $echo (param i32 i32 i32) (result i32)))
+  (memory (export "memory") 1 200)
+  (global $next (mut i32) (i32.const 1024))
+  (global $pending (mut i32) (i32.const 0))
+  (global $out (mut i32) (i32.const 0))
+  (global $hin (mut i32) (i32.const 0))
+  (global $frees (export "frees") (mut i32) (i32.const 0))
+  (func (export "wasmy_alloc") (param $size i32) (result i32)
+    (local $p i32) (local $end i32) (local $have i32)
+    (local.set $p (global.get $next))
+    (local.set $end (i32.add (local.get $p) (local.get $size)))
+    (local.set $have (i32.mul (memory.size) (i32.const 65536)))
+    (if (i32.gt_u (local.get $end) (local.get $have))
+      (then
+        (if (i32.eq (memory.grow (i32.add (i32.shr_u (i32.sub (local.get $end) (local.get $have)) (i32.const 16)) (i32.const 1))) (i32.const -1))
+          (then (return (i32.const 0))))))
+    (global.set $next (local.get $end))
+    (global.set $pending (local.get $p))
+    (local.get $p))
+  (func (export "wasmy_free") (param $p i32)
+    (global.set $frees (i32.add (global.get $frees) (i32.const 1))))
+  (func (export "inputBuffer") (result i32) (global.get $pending))
+  (func (export "outputBuffer") (result i32) (global.get $out))
+  (func (export "hostInputBuffer") (result i32) (global.get $hin))
+  (func (export "hostOutputBuffer") (result i32) (global.get $pending))
+  (func (export "callHost") (param $len i32) (result i32)
+    (local $n i32)
+    (global.set $hin (global.get $pending))
+    (local.set $n (call $echo (local.get $len) (i32.const 0) (i32.const 0)))
+    (global.set $out (global.get $pending))
+    (local.get $n))
+)`
+
+func TestRunAllocatedBuffers(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(testAllocGuestWAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err = r.WarmUp(engine, module, nil, "callHost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.bufs.Dynamic() {
+		t.Fatal("expected the allocator ABI to be picked up")
+	}
+
+	// bigger than a fixed buffer, the args, the host input and the host output
+	// each get their own allocation
+	big := strings.Repeat("x", 2*interfaces.FUNCBUFFER_SIZE)
+	out, err := r.Run("callHost", big)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != big {
+		t.Fatalf("expected the big payload back, got %d bytes", len(out.Data.(string)))
+	}
+
+	out, err = r.Run("callHost")
+	if err != nil || out.Data != nil {
+		t.Fatalf("expected an empty payload, got %+v, %v", out, err)
+	}
+
+	// the guest memory can't grow to fit this, so wasmy_alloc returns 0
+	_, err = r.Run("callHost", strings.Repeat("x", 200*PageSize))
+	if !errors.Is(err, ErrAllocFailed) {
+		t.Fatalf("expected ErrAllocFailed, got %v", err)
+	}
+
+	frees := r.instance.GetExport(r.store, "frees").Global().Get(r.store).I32()
+	if frees != 0 {
+		t.Errorf("expected the host to leave handed over buffers to the guest, got %d frees", frees)
+	}
+}
+
+func TestValidateAllocExports(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(`
+(module
+  (memory (export "memory") 1)
+  (func (export "inputBuffer") (result i32) (i32.const 0))
+  (func (export "outputBuffer") (result i32) (i32.const 0))
+  (func (export "hostInputBuffer") (result i32) (i32.const 0))
+  (func (export "hostOutputBuffer") (result i32) (i32.const 0))
+  (func (export "wasmy_alloc") (param i32) (result i32) (i32.const 0)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{}
+	err = r.WarmUp(engine, module, nil)
+	if !errors.Is(err, ErrMissingExport) || !strings.Contains(err.Error(), freeExport) {
+		t.Fatalf("expected wasmy_free to be missing, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// DenyMode is what a Policy does with a host function it doesn't grant
+type DenyMode int
+
+const (
+	// DenyStub links a stub instead, every call to it fails with a
+	// shared_types.ErrCodePermissionDenied error and is audited
+	DenyStub DenyMode = iota
+	// DenyReject fails WarmUp with ErrPermissionDenied when the module imports it
+	DenyReject
+)
+
+// Policy is the allowlist of host capabilities of an instance, e.g. only trusted
+// plugins get `http_fetch`. WASI and built-in imports like `wasmy:stream` are
+// always granted.
+type Policy struct {
+	// Name identifies the instance in the audit log, e.g. the name of the plugin
+	Name string
+	// Grants are the host functions the instance may call as `module/name`, a name
+	// without a module is a function in Runner.HostFunctions and `module/*` grants
+	// every function of a module
+	Grants []string
+	// Deny is what happens to the host functions that aren't granted
+	Deny DenyMode
+	// Audit gets every denied call and rejected import, nil writes them to stderr
+	Audit func(AuditEvent)
+}
+
+// NewPolicy creates a policy that grants imports and stubs out everything else
+func NewPolicy(name string, grants ...string) *Policy {
+	return &Policy{Name: name, Grants: grants}
+}
+
+// Grant adds imports to the allowlist
+func (p *Policy) Grant(imports ...string) *Policy {
+	p.Grants = append(p.Grants, imports...)
+	return p
+}
+
+// Allows reports whether the policy grants the host function module/name
+func (p *Policy) Allows(module, name string) bool {
+	if !needsRequest(module) {
+		return true
+	}
+
+	for _, grant := range p.Grants {
+		mod, n := splitImport(grant)
+		if mod == module && (n == name || n == "*") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Audit actions
+const (
+	// AuditDeniedCall is a call to a stubbed out host function
+	AuditDeniedCall = "denied_call"
+	// AuditRejectedImport is an import that failed WarmUp
+	AuditRejectedImport = "rejected_import"
+)
+
+// AuditEvent records a host function the policy kept from an instance
+type AuditEvent struct {
+	Time time.Time
+	// Instance is Policy.Name
+	Instance string
+	// Import is the host function as `module/name`
+	Import string
+	// Action is one of the Audit constants
+	Action string
+}
+
+func (e AuditEvent) String() string {
+	return fmt.Sprintf("%s wasmy audit: instance=%q import=%s action=%s", e.Time.Format(time.RFC3339), e.Instance, e.Import, e.Action)
+}
+
+func (p *Policy) audit(imp hostImport, action string) {
+	e := AuditEvent{Time: time.Now(), Instance: p.Name, Import: imp.String(), Action: action}
+	if p.Audit != nil {
+		p.Audit(e)
+		return
+	}
+
+	os.Stderr.WriteString(e.String() + "\n")
+}
+
+// linkable reports whether the host function module/name is linked at all, the
+// manifest has to request it and the policy grant it or stub it out
+func (r *Runner) linkable(module, name string) bool {
+	if r.Manifest != nil && !r.Manifest.Requests(module, name) {
+		return false
+	}
+
+	return r.Policy == nil || r.Policy.Deny == DenyStub || r.Policy.Allows(module, name)
+}
+
+// denied returns the stub linked in place of a host function the policy doesn't
+// grant, or nil if it is granted
+func (r *Runner) denied(module, name string) ContextHostFunc {
+	if r.Policy == nil || r.Policy.Allows(module, name) {
+		return nil
+	}
+
+	imp := hostImport{module: module, name: name}
+	policy := r.Policy
+	return func(_ *HostCallContext, _ *shared_types.Args) (interface{}, error) {
+		policy.audit(imp, AuditDeniedCall)
+		return nil, &shared_types.Error{
+			Code:    shared_types.ErrCodePermissionDenied,
+			Message: fmt.Sprintf("%s is not granted to %q", imp, policy.Name),
+		}
+	}
+}
+
+// policyErrs rejects the imports of module that the policy doesn't grant when
+// it is in DenyReject mode, imports the manifest doesn't request are already
+// reported by it
+func (r *Runner) policyErrs(module *wasmtime.Module) []error {
+	if r.Policy == nil || r.Policy.Deny != DenyReject {
+		return nil
+	}
+
+	var errs []error
+	for _, imp := range module.Imports() {
+		name := ""
+		if imp.Name() != nil {
+			name = *imp.Name()
+		}
+
+		if imp.Type().FuncType() == nil || r.Policy.Allows(imp.Module(), name) ||
+			(r.Manifest != nil && !r.Manifest.Requests(imp.Module(), name)) {
+			continue
+		}
+
+		hi := hostImport{module: imp.Module(), name: name}
+		r.Policy.audit(hi, AuditRejectedImport)
+		errs = append(errs, fmt.Errorf("%w: %s is not granted to %q", ErrPermissionDenied, hi, r.Policy.Name))
+	}
+
+	return errs
+}
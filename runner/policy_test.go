@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// policyRunner warms up the test guest with echo as a host function under policy
+func policyRunner(t *testing.T, policy *Policy) (*Runner, error) {
+	t.Helper()
+
+	engine := GetEngine()
+	r := &Runner{Policy: policy}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	return r, r.WarmUp(engine, testModule(t, engine, &shared_types.Payload{Data: "ok"}), nil, "callHost")
+}
+
+func TestPolicyStub(t *testing.T) {
+	var events []AuditEvent
+	policy := NewPolicy("untrusted")
+	policy.Audit = func(e AuditEvent) {
+		events = append(events, e)
+	}
+
+	r, err := policyRunner(t, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Run("callHost", "hi")
+
+	gErr := &GuestError{}
+	if !errors.As(err, &gErr) || gErr.Code != shared_types.ErrCodePermissionDenied {
+		t.Fatalf("expected a permission denied error, got %v", err)
+	}
+
+	if len(events) != 1 || events[0].Instance != "untrusted" || events[0].Import != "env/main.echo" || events[0].Action != AuditDeniedCall {
+		t.Fatalf("unexpected audit log %+v", events)
+	}
+
+	// granted by name and by module
+	for _, grant := range []string{"echo", "env/*"} {
+		r, err = policyRunner(t, NewPolicy("trusted", grant))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := r.Run("callHost", "hi")
+		if err != nil || out.Data != "hi" {
+			t.Fatalf("expected %s to grant echo, got %+v, %v", grant, out, err)
+		}
+	}
+}
+
+func TestPolicyHostModule(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testHostModuleWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hm := NewHostModule("wasmy:test")
+	hm.Define("echo", echo)
+
+	for _, c := range []struct {
+		policy *Policy
+		denied bool
+	}{
+		{NewPolicy("untrusted", "wasmy:other/*"), true},
+		{NewPolicy("trusted", "wasmy:test/echo"), false},
+	} {
+		c.policy.Audit = func(AuditEvent) {}
+		p, err := NewPool(engine, module, &PoolConfig{
+			Size:        1,
+			HostModules: []*HostModule{hm},
+			FuncNames:   []string{"callHost"},
+			Policy:      c.policy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := p.Run(context.Background(), "callHost", "martin")
+		p.Close()
+
+		gErr := &GuestError{}
+		denied := errors.As(err, &gErr) && gErr.Code == shared_types.ErrCodePermissionDenied
+		if denied != c.denied || (!c.denied && out.Data != "martin") {
+			t.Fatalf("%s: unexpected result %+v, %v", c.policy.Name, out, err)
+		}
+	}
+}
+
+func TestPolicyReject(t *testing.T) {
+	var events []AuditEvent
+	policy := &Policy{Name: "untrusted", Deny: DenyReject, Audit: func(e AuditEvent) {
+		events = append(events, e)
+	}}
+
+	_, err := policyRunner(t, policy)
+	if !errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrUnresolvedImport) {
+		t.Fatalf("expected only ErrPermissionDenied, got %v", err)
+	}
+
+	if len(events) != 1 || events[0].Import != "env/main.echo" || events[0].Action != AuditRejectedImport {
+		t.Fatalf("unexpected audit log %+v", events)
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	p := NewPolicy("plugin", "wasmy:http/fetch", "wasmy:log/*", "echo")
+
+	for _, c := range []struct {
+		module, name string
+		want         bool
+	}{
+		{"wasmy:http", "fetch", true},
+		{"wasmy:http", "post", false},
+		{"wasmy:log", "write", true},
+		{"env", "main.echo", true},
+		{"env", "main.other", false},
+		{wasiModule, "fd_write", true},
+		{"wasmy:stream", "read", true},
+	} {
+		if got := p.Allows(c.module, c.name); got != c.want {
+			t.Errorf("Allows(%s, %s) = %v, want %v", c.module, c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// PoolConfig sets up how a Pool warms, hands out and evicts Runner instances
+type PoolConfig struct {
+	// Size is the number of instances pre-warmed by NewPool, idle eviction
+	// never takes the pool below this number
+	Size int
+	// MaxSize is the maximum number of instances that can be checked out at
+	// the same time, defaults to Size
+	MaxSize int
+	// IdleTimeout evicts idle instances above Size once they have not been used
+	// for this long, 0 disables eviction
+	IdleTimeout time.Duration
+	// FailFast makes Get return ErrPoolExhausted instead of blocking when every
+	// instance is checked out
+	FailFast bool
+	// HostFunctions are wrapped for every instance with Runner.WrapExport
+	HostFunctions map[string]HostFunc
+	// HostModules are linked into every instance
+	HostModules []*HostModule
+	// WasiConfig is called for every new instance, a WasiConfig can only be
+	// used by a single store. If nil WASI is used. Deprecated, use WASI, the
+	// two can't be set together.
+	WasiConfig func() *wasmtime.WasiConfig
+	// WASI is set as Runner.WASI on every instance
+	WASI *WASIOptions
+	// Manifest is set as Runner.Manifest on every instance
+	Manifest *Manifest
+	// Policy is set as Runner.Policy on every instance
+	Policy *Policy
+	// FuncNames are the guest functions to warm up in every instance
+	FuncNames []string
+	// FuelBudget is set as Runner.FuelBudget on every instance
+	FuelBudget uint64
+	// RunnerConfig sets the memory limits of every instance
+	RunnerConfig *RunnerConfig
+	// Capture is set as Runner.Capture on every instance
+	Capture *OutputCapture
+}
+
+// Pool keeps a set of warmed-up Runners for a single module so that guest
+// functions can be called concurrently, each Runner is only ever used by one
+// caller at a time.
+type Pool struct {
+	engine *wasmtime.Engine
+	module *wasmtime.Module
+	cfg    PoolConfig
+
+	// tokens holds one entry for every checked out instance
+	tokens chan struct{}
+
+	mu     sync.Mutex
+	idle   []*idleRunner
+	closed bool
+	done   chan struct{}
+
+	// refilling counts the instances being warmed up to replace discarded ones
+	refilling int
+	refills   sync.WaitGroup
+}
+
+type idleRunner struct {
+	r        *Runner
+	lastUsed time.Time
+}
+
+// NewPool creates a pool for module and pre-warms cfg.Size instances
+func NewPool(engine *wasmtime.Engine, module *wasmtime.Module, cfg *PoolConfig) (*Pool, error) {
+	p := &Pool{
+		engine: engine,
+		module: module,
+		done:   make(chan struct{}),
+	}
+
+	if cfg != nil {
+		p.cfg = *cfg
+	}
+
+	if p.cfg.MaxSize < p.cfg.Size {
+		p.cfg.MaxSize = p.cfg.Size
+	}
+
+	if p.cfg.MaxSize < 1 {
+		p.cfg.MaxSize = 1
+	}
+
+	p.tokens = make(chan struct{}, p.cfg.MaxSize)
+
+	for i := 0; i < p.cfg.Size; i++ {
+		r, err := p.newRunner()
+		if err != nil {
+			return nil, err
+		}
+
+		p.idle = append(p.idle, &idleRunner{r: r, lastUsed: time.Now()})
+	}
+
+	if p.cfg.IdleTimeout > 0 {
+		go p.evictLoop()
+	}
+
+	return p, nil
+}
+
+// newRunner creates and warms up a Runner with the pool host functions
+func (p *Pool) newRunner() (*Runner, error) {
+	r := &Runner{
+		HostFunctions: make(map[string]ExportFunc),
+		HostModules:   p.cfg.HostModules,
+		Config:        p.cfg.RunnerConfig,
+		FuelBudget:    p.cfg.FuelBudget,
+		Capture:       p.cfg.Capture,
+		WASI:          p.cfg.WASI,
+		Manifest:      p.cfg.Manifest,
+		Policy:        p.cfg.Policy,
+	}
+
+	for name, fn := range p.cfg.HostFunctions {
+		r.HostFunctions[name] = r.WrapExport(fn)
+	}
+
+	var wasiConf *wasmtime.WasiConfig
+	if p.cfg.WasiConfig != nil {
+		wasiConf = p.cfg.WasiConfig()
+	}
+
+	err := r.WarmUp(p.engine, p.module, wasiConf, p.cfg.FuncNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Get checks out a Runner from the pool, it will warm up a new instance if there
+// are no idle ones and the pool is below MaxSize. When the pool is exhausted Get
+// blocks until an instance is returned or ctx is done, unless FailFast is set.
+// Every Runner from Get must be handed back with Put.
+func (p *Pool) Get(ctx context.Context) (*Runner, error) {
+	if p.isClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	if p.cfg.FailFast {
+		select {
+		case p.tokens <- struct{}{}:
+		default:
+			return nil, ErrPoolExhausted
+		}
+	} else {
+		select {
+		case p.tokens <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.tokens
+		return nil, ErrPoolClosed
+	}
+
+	if n := len(p.idle); n > 0 {
+		ir := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return ir.r, nil
+	}
+	p.mu.Unlock()
+
+	r, err := p.newRunner()
+	if err != nil {
+		<-p.tokens
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Put returns a Runner to the pool, it is dropped if the pool has been closed or
+// the instance was discarded after an interrupted call. A discarded instance is
+// replaced in the background if that leaves the pool below Size.
+func (p *Pool) Put(r *Runner) {
+	p.mu.Lock()
+	if !p.closed && r != nil {
+		if !r.Discarded() {
+			p.idle = append(p.idle, &idleRunner{r: r, lastUsed: time.Now()})
+		} else if len(p.idle)+len(p.tokens)-1+p.refilling < p.cfg.Size {
+			p.refilling++
+			p.refills.Add(1)
+			go p.refill()
+		}
+	}
+	p.mu.Unlock()
+
+	<-p.tokens
+}
+
+// refill warms up an instance to replace a discarded one, if that fails Get warms
+// one up when it is needed instead
+func (p *Pool) refill() {
+	defer p.refills.Done()
+
+	r, err := p.newRunner()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.refilling--
+	if err != nil || p.closed {
+		return
+	}
+
+	p.idle = append(p.idle, &idleRunner{r: r, lastUsed: time.Now()})
+}
+
+// Run checks out a Runner, calls the guest function name with RunContext and
+// hands the Runner back to the pool
+func (p *Pool) Run(ctx context.Context, name string, args ...interface{}) (*shared_types.Payload, error) {
+	r, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(r)
+
+	return r.RunContext(ctx, name, args...)
+}
+
+// Stream checks out an instance, calls Runner.Stream on it and returns it to the pool
+func (p *Pool) Stream(ctx context.Context, name string, in io.Reader, out io.Writer, args ...interface{}) (*shared_types.Payload, error) {
+	r, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(r)
+
+	return r.Stream(ctx, name, in, out, args...)
+}
+
+// Idle returns the number of warmed up instances waiting to be checked out
+func (p *Pool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle)
+}
+
+// Size returns the number of instances in the pool, idle and checked out. It
+// can drop below PoolConfig.Size for as long as a discarded instance is being
+// replaced.
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.idle) + len(p.tokens)
+}
+
+// Close drops all idle instances and stops the eviction loop, checked out
+// instances are dropped when they are returned
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	p.idle = nil
+	close(p.done)
+}
+
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
+
+// evictLoop periodically drops instances that have been idle for longer than
+// IdleTimeout, keeping at least Size instances warm
+func (p *Pool) evictLoop() {
+	interval := p.cfg.IdleTimeout / 2
+	if interval <= 0 {
+		interval = p.cfg.IdleTimeout
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-t.C:
+			p.evict(now)
+		}
+	}
+}
+
+func (p *Pool) evict(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// idle is ordered by lastUsed as instances are appended on Put, so the
+	// stalest instances are at the front
+	n := 0
+	for n < len(p.idle) && len(p.idle)-n > p.cfg.Size && now.Sub(p.idle[n].lastUsed) >= p.cfg.IdleTimeout {
+		n++
+	}
+
+	p.idle = p.idle[n:]
+}
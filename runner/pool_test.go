@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+func testPoolConfig(cfg PoolConfig) *PoolConfig {
+	cfg.HostFunctions = map[string]HostFunc{"echo": echo}
+	cfg.FuncNames = []string{"constant", "callHost"}
+	return &cfg
+}
+
+func TestPoolRunConcurrent(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	p, err := NewPool(engine, module, testPoolConfig(PoolConfig{Size: 2, MaxSize: 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.Idle() != 2 {
+		t.Fatalf("expected 2 pre-warmed instances, got %d", p.Idle())
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			arg := fmt.Sprintf("call-%d", i)
+			out, err := p.Run(context.Background(), "callHost", arg)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if out.Data != arg {
+				errs <- fmt.Errorf("expected %s, got %v", arg, out.Data)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if p.Idle() > 4 {
+		t.Fatalf("pool grew past MaxSize: %d idle", p.Idle())
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	p, err := NewPool(engine, module, testPoolConfig(PoolConfig{Size: 1, FailFast: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	r, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.Get(context.Background())
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("expected ErrPoolExhausted, got %v", err)
+	}
+
+	p.Put(r)
+
+	// a blocking pool waits for the context instead
+	p.cfg.FailFast = false
+	r, _ = p.Get(context.Background())
+	defer p.Put(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = p.Get(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestPoolIdleEviction(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	p, err := NewPool(engine, module, testPoolConfig(PoolConfig{Size: 1, MaxSize: 3, IdleTimeout: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var runners []*Runner
+	for i := 0; i < 3; i++ {
+		r, err := p.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		runners = append(runners, r)
+	}
+
+	for _, r := range runners {
+		p.Put(r)
+	}
+
+	p.evict(time.Now().Add(time.Second))
+
+	if p.Idle() != 1 {
+		t.Fatalf("expected eviction down to Size, got %d idle", p.Idle())
+	}
+}
+
+func TestPoolRefill(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	p, err := NewPool(engine, module, testPoolConfig(PoolConfig{Size: 2, MaxSize: 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	r, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Size() != 2 {
+		t.Fatalf("expected 2 instances with one checked out, got %d", p.Size())
+	}
+
+	// a discarded instance is replaced to get back to Size
+	r.discarded = true
+	p.Put(r)
+	p.refills.Wait()
+
+	if p.Idle() != 2 || p.Size() != 2 {
+		t.Fatalf("expected 2 idle instances after the refill, got %d idle of %d", p.Idle(), p.Size())
+	}
+
+	for _, ir := range p.idle {
+		if ir.r == r {
+			t.Fatal("discarded instance is back in the pool")
+		}
+	}
+
+	// above Size it isn't
+	var runners []*Runner
+	for i := 0; i < 3; i++ {
+		r, err := p.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		runners = append(runners, r)
+	}
+
+	runners[0].discarded = true
+	for _, r := range runners {
+		p.Put(r)
+	}
+	p.refills.Wait()
+
+	if p.Size() != 2 {
+		t.Fatalf("expected the pool to shrink back to 2 instances, got %d", p.Size())
+	}
+}
@@ -3,102 +3,229 @@
 package runner
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sync"
 
 	wasmtime "github.com/bytecodealliance/wasmtime-go"
 	shared_types "github.com/lonelycode/wasmy/shared-types"
-	"github.com/tinylib/msgp/msgp"
 )
 
 // Runner provides methods that manages all the components of running multiple WASM
 // modules and calling arbitrary functions from them using managed I/O
 type Runner struct {
 	// HostFunctions are functions the host should expose to the nwasm file
-	HostFunctions      map[string]ExportFunc
-	mem                *wasmtime.Memory
-	store              *wasmtime.Store
-	instance           *wasmtime.Instance
-	inputBufferFn      *wasmtime.Func
-	outputBufferFn     *wasmtime.Func
-	hostInputBufferFn  *wasmtime.Func
-	hostOutputBufferFn *wasmtime.Func
-	FuncMap            map[string]*wasmtime.Func
+	HostFunctions map[string]ExportFunc
+	// HostModules expose host functions under their own import module names
+	HostModules []*HostModule
+	engine      *wasmtime.Engine
+	mem         *wasmtime.Memory
+	store       *wasmtime.Store
+	instance    *wasmtime.Instance
+	bufs        *GuestBuffers
+	stream      *stream
+	FuncMap     map[string]*wasmtime.Func
+	// Codec is the codec the host wants to talk to the guest in, nil lets a guest
+	// with a `wasmy_codec` export pick any of shared_types.Codecs and assumes
+	// msgpack for one without
+	Codec shared_types.Codec
+	// codec is the codec agreed on in WarmUp
+	codec shared_types.Codec
+	// abi is the ABI version of the guest
+	abi int32
+	// WASI configures the WASI access of every instance, nil only shares stdout
+	// and stderr. It can't be combined with a WasiConfig passed to WarmUp or
+	// GetInstance.
+	WASI *WASIOptions
+	// Manifest is checked against the module in WarmUp, only the host functions
+	// it requests are linked and Run checks args against its argument types
+	Manifest *Manifest
+	// Policy is the allowlist of host functions of the instance, nil grants all
+	Policy *Policy
+	// Capture sends the guest stdout and stderr to a writer instead of the host
+	// stdout and stderr, nil inherits them
+	Capture *OutputCapture
+	capture *capture
+	// Config sets the memory limits of the instance, nil means no limits
+	Config *RunnerConfig
+	// FuelBudget is the fuel every call may consume when the engine has fuel
+	// consumption enabled, 0 means unlimited
+	FuelBudget uint64
+	fuelAdded  uint64
+	// MaxCallDepth limits how deep host functions can nest calls back into the
+	// guest with a HostCallContext, 0 means DefaultMaxCallDepth
+	MaxCallDepth int
+	// depth is the number of guest calls in progress and ctx the context of the
+	// outermost one
+	depth int
+	ctx   context.Context
+	// discarded is set once a guest call was interrupted, ran out of fuel or went
+	// over its memory quota, the guest may have been stopped half way through
+	// changing its own state
+	discarded bool
+	// ctxDone is set once a host function trapped the guest because the ctx of the
+	// call was done
+	ctxDone bool
 }
 
 // ExportFun represents the signature needed for any function exported by
 // the host and imported by the WASM file
 type ExportFunc func(int32, int32, int32) int32
 
+// HostFunc is the signature of a host function before it has been wrapped for
+// a specific Runner with WrapExport (see example/exports.go)
+type HostFunc func(*shared_types.Args) (interface{}, error)
+
 // WrapExport will wrap any function to be exported by the HOST and used by the WASM
 // module in order to capture the input args for the function and the output from the
 // function and pass the data cleanly to the WASM module (see the exports package
-// for an example function that can be wrapped).
-func (r *Runner) WrapExport(fn func(*shared_types.Args) (interface{}, error)) ExportFunc {
+// for an example function that can be wrapped). Errors are sent back to the guest in
+// the Payload.Error envelope, return a *shared_types.Error from fn to set your own
+// error code and details.
+func (r *Runner) WrapExport(fn HostFunc) ExportFunc {
+	return r.WrapContextExport(func(_ *HostCallContext, args *shared_types.Args) (interface{}, error) {
+		return fn(args)
+	})
+}
+
+// WrapContextExport is WrapExport for a host function that calls back into the
+// guest, fn gets a HostCallContext for the call that is only valid until it returns
+func (r *Runner) WrapContextExport(fn ContextHostFunc) ExportFunc {
 	return func(dataLen int32, t2 int32, t3 int32) int32 {
+		ret, err := r.callHostFunc(dataLen, fn)
+		if err != nil {
+			return r.externHostErr(err)
+		}
+
+		// a nil result is sent as an empty output, see ManagedCall
+		if ret == nil && !r.bufs.LegacyEnvelopes {
+			return 0
+		}
+
+		// Encode the output back into the guest VM
+		out := &shared_types.Payload{Data: ret}
 
-		ptr, err := r.hostInputBufferFn.Call(r.store)
+		enc, err := r.wireCodec().Marshal(out)
 		if err != nil {
-			// fmt.Printf("failed to call inputBufferFn: %v\n", err)
-			os.Stderr.WriteString(err.Error())
-			return -1
+			return r.externHostErr(err)
 		}
 
-		outPtr, err := r.hostOutputBufferFn.Call(r.store)
+		outputLen, err := r.bufs.put(r.store, r.mem, r.bufs.HostOutput, enc)
 		if err != nil {
-			os.Stderr.WriteString(err.Error())
-			// fmt.Printf("failed to call hostOutputBufferFn: %v\n", err)
-			return -1
+			return r.externHostErr(err)
 		}
 
-		hostArgs := &shared_types.Args{}
-		outDat := make([]byte, dataLen)
-		copy(outDat[:], r.mem.UnsafeData(r.store)[int(ptr.(int32)):int(ptr.(int32))+int(dataLen)])
-		buf := bytes.NewBuffer(outDat)
-		err = msgp.Decode(buf, hostArgs)
+		// return how much we wrote
+		return outputLen
+	}
+
+}
+
+// callHostFunc reads the args of a host function call from the host input buffer
+// and calls fn with them
+func (r *Runner) callHostFunc(dataLen int32, fn ContextHostFunc) (interface{}, error) {
+	// a guest that is over its memory quota gets no more host calls
+	if err := r.checkMemory(); err != nil {
+		return nil, err
+	}
+
+	// an empty input is a call without args
+	hostArgs := &shared_types.Args{}
+	if dataLen != 0 {
+		inDat, err := r.bufs.get(r.store, r.mem, r.bufs.HostInput, dataLen)
 		if err != nil {
-			// fmt.Printf("failed to decode: %v\n", err)
-			os.Stderr.WriteString(err.Error())
-			return -1
+			return nil, err
 		}
 
-		// call the actual functions
-		ret, err := fn(hostArgs)
+		err = r.wireCodec().Unmarshal(inDat, hostArgs)
 		if err != nil {
-			// fmt.Printf("failed to call function: %v\n", err)
-			os.Stderr.WriteString(err.Error())
-			return -1
+			return nil, err
 		}
+	}
 
-		// Encode the output back into the guest VM
-		out := &shared_types.Payload{Data: ret}
+	// call the actual functions
+	cc := &HostCallContext{r: r, ctx: r.ctx}
+	defer cc.done()
 
-		enc, err := out.MarshalMsg(nil)
-		if err != nil {
-			// fmt.Printf("failed to call marshal for output: %v\n", err)
-			os.Stderr.WriteString(err.Error())
-			return -1
+	return fn(cc, hostArgs)
+}
+
+// guard traps the guest instead of calling fn once the ctx of the call is done, and
+// after fn if it got done in the meantime, that is how a cancelled RunContext
+// stops the guest. It runs on the goroutine of the call, the store is never
+// touched from anywhere else.
+func (r *Runner) guard(fn ExportFunc) func(int32, int32, int32) (int32, *wasmtime.Trap) {
+	return func(a, b, c int32) (int32, *wasmtime.Trap) {
+		if trap := r.doneTrap(); trap != nil {
+			return 0, trap
 		}
 
-		outputLen := copy(r.mem.UnsafeData(r.store)[int(outPtr.(int32)):int(outPtr.(int32))+len(enc)], enc)
+		ret := fn(a, b, c)
+		if trap := r.doneTrap(); trap != nil {
+			return 0, trap
+		}
 
-		// return how much we wrote
-		return int32(outputLen)
+		return ret, nil
+	}
+}
+
+func (r *Runner) doneTrap() *wasmtime.Trap {
+	if r.ctx == nil || r.ctx.Err() == nil {
+		return nil
 	}
 
+	r.ctxDone = true
+	return wasmtime.NewTrap(fmt.Sprintf("guest call stopped: %v", r.ctx.Err()))
 }
 
-// AddHostFunctions adds functions that can be imported into the WASM module,
-// multiple funcs can be added, they all live in the `env` namespace
-func (r *Runner) AddHostFunctions(linker *wasmtime.Linker) {
-	if r.HostFunctions == nil {
-		return
+// externHostErr writes err into the host output buffer as the Error envelope of a
+// Payload, if err is (or wraps) a *shared_types.Error its code and details are kept.
+// If even that fails the guest gets -1.
+func (r *Runner) externHostErr(err error) int32 {
+	hErr := &shared_types.Error{}
+	if !errors.As(err, &hErr) {
+		hErr = &shared_types.Error{Code: shared_types.ErrCodeHost, Message: err.Error()}
+	}
+
+	enc, err := r.wireCodec().Marshal(&shared_types.Payload{Error: hErr})
+	if err != nil {
+		return -1
+	}
+
+	n, err := r.bufs.put(r.store, r.mem, r.bufs.HostOutput, enc)
+	if err != nil {
+		return -1
 	}
 
+	return n
+}
+
+// AddHostFunctions adds functions that can be imported into the WASM module,
+// multiple funcs can be added, they all live in the `env` namespace. It returns a
+// *ValidationError listing every function that could not be defined.
+func (r *Runner) AddHostFunctions(linker *wasmtime.Linker) error {
+	return validationErr(r.addHostFunctions(linker))
+}
+
+func (r *Runner) addHostFunctions(linker *wasmtime.Linker) []error {
+	var errs []error
 	for name, fn := range r.HostFunctions {
-		linker.DefineFunc(r.store, "env", fmt.Sprintf("main.%s", name), fn)
+		if !r.linkable(legacyHostModule, "main."+name) {
+			continue
+		}
+
+		if stub := r.denied(legacyHostModule, "main."+name); stub != nil {
+			fn = r.WrapContextExport(stub)
+		}
+
+		err := linker.DefineFunc(r.store, legacyHostModule, fmt.Sprintf("main.%s", name), r.guard(fn))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to link host function %s/main.%s: %w", legacyHostModule, name, err))
+		}
 	}
+
+	return errs
 }
 
 func GetModule(filename string, engine *wasmtime.Engine) (*wasmtime.Module, error) {
@@ -111,15 +238,53 @@ func GetModule(filename string, engine *wasmtime.Engine) (*wasmtime.Module, erro
 }
 
 // GetInstance provides a WASM VM instance from the file name. It enables WASI,
-// but only shares stdout and stderr for easier logging.
+// but only shares stdout and stderr for easier logging, or captures them when
+// Runner.Capture is set. Runner.WASI adds to that.
+//
+// wasiConf is deprecated, pass nil and use Runner.WASI. A wasiConf replaces the
+// defaults altogether, stdout and stderr aren't shared or captured, and it can't
+// be used together with Runner.WASI (ErrWASIConflict).
 func (r *Runner) GetInstance(module *wasmtime.Module, engine *wasmtime.Engine, wasiConf *wasmtime.WasiConfig) (*wasmtime.Instance, *wasmtime.Store, error) {
+	r.engine = engine
 	r.store = wasmtime.NewStore(engine)
-	wConf := wasmtime.NewWasiConfig()
-	wConf.InheritStdout()
-	wConf.InheritStderr()
+	r.discarded = false
 
-	if wasiConf != nil {
-		wConf = wasiConf
+	// When epoch interruption is enabled a store traps as soon as its deadline
+	// is reached, and a new store starts with a deadline of 0
+	r.store.SetEpochDeadline(noEpochDeadline)
+
+	r.fuelAdded = 0
+	if r.fuelEnabled() {
+		err := r.setFuel(unlimitedFuel)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if wasiConf != nil && r.WASI != nil {
+		return nil, nil, ErrWASIConflict
+	}
+
+	wConf := wasiConf
+	if wConf == nil {
+		var err error
+		wConf, err = r.WASI.config()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.capture != nil {
+		r.capture.close()
+		r.capture = nil
+	}
+
+	if r.Capture != nil {
+		var err error
+		r.capture, err = newCapture(r.Capture, wConf)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	r.store.SetWasi(wConf)
@@ -131,8 +296,28 @@ func (r *Runner) GetInstance(module *wasmtime.Module, engine *wasmtime.Engine, w
 		return nil, nil, err
 	}
 
-	// Set up the host functions we want to import
-	r.AddHostFunctions(linker)
+	// Set up the host functions we want to import, and make sure that covers
+	// everything the module imports before instantiating it
+	errs := r.addHostFunctions(linker)
+	errs = append(errs, r.linkHostModules(linker)...)
+	errs = append(errs, r.manifestErrs(module)...)
+	errs = append(errs, r.policyErrs(module)...)
+	errs = append(errs, unresolvedImports(linker, r.store, module, r.linkable)...)
+
+	err = validationErr(errs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	helper := &wasiHelper{engine: engine, linker: linker, store: r.store}
+	err = r.WASI.restrict(helper)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.capture != nil {
+		r.capture.helper = helper
+	}
 
 	// Next up we instantiate a module which is where we link in all our
 	// imports.
@@ -146,52 +331,222 @@ func (r *Runner) GetInstance(module *wasmtime.Module, engine *wasmtime.Engine, w
 
 // GetRequiredExports gets the expoerted WASM functions needed to make managed I/O work,
 // these functions MUST be declared in the WASM module as exported functions as boilerplate,
-// they are provided by a WasmModulePrototype instance.
-func (r *Runner) GetRequiredExports(instance *wasmtime.Instance, store *wasmtime.Store) {
-	r.mem = instance.GetExport(store, "memory").Memory()
+// they are provided by a WasmModulePrototype instance. Missing exports are returned
+// as a *ValidationError.
+func (r *Runner) GetRequiredExports(instance *wasmtime.Instance, store *wasmtime.Store) error {
+	var errs []error
+
+	mem := instance.GetExport(store, "memory")
+	if mem == nil || mem.Memory() == nil {
+		errs = append(errs, fmt.Errorf("%w: memory", ErrMissingExport))
+	} else {
+		r.mem = mem.Memory()
+	}
+
+	getFunc := func(name string) *wasmtime.Func {
+		fn := instance.GetFunc(store, name)
+		if fn == nil {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrMissingExport, name))
+		}
+
+		return fn
+	}
 
-	r.mem.Grow(store, 19)
+	r.bufs = &GuestBuffers{
+		Input:      getFunc("inputBuffer"),
+		Output:     getFunc("outputBuffer"),
+		HostInput:  getFunc("hostInputBuffer"),
+		HostOutput: getFunc("hostOutputBuffer"),
 
-	r.inputBufferFn = instance.GetExport(store, "inputBuffer").Func()
-	r.outputBufferFn = instance.GetExport(store, "outputBuffer").Func()
+		// the allocator ABI is optional, validateExports makes sure a module
+		// has both or neither
+		Alloc: instance.GetFunc(store, allocExport),
+		Free:  instance.GetFunc(store, freeExport),
+	}
 
-	r.hostInputBufferFn = instance.GetExport(store, "hostInputBuffer").Func()
-	r.hostOutputBufferFn = instance.GetExport(store, "hostOutputBuffer").Func()
+	return validationErr(errs)
 }
 
 // WarmUp will load and prepare a WASM module instance and create a call map for the
 // runner to call, this means the wasm module can be warmed up in advance to minimise
-// execution time of WASM funcs.
+// execution time of WASM funcs. The module is validated first, every missing export,
+// export with the wrong signature and unresolved import is returned together in a
+// *ValidationError.
 func (r *Runner) WarmUp(engine *wasmtime.Engine, module *wasmtime.Module, wasiConf *wasmtime.WasiConfig, funcNames ...string) error {
+	if r.FuelBudget > 0 {
+		state := getEngineState(engine)
+		if state == nil || !state.cfg.ConsumeFuel {
+			return ErrFuelDisabled
+		}
+	}
+
+	// the exports in the manifest are warmed up as well
+	if r.Manifest != nil {
+		funcNames = funcNames[:len(funcNames):len(funcNames)]
+		names := make(map[string]bool)
+		for _, name := range funcNames {
+			names[name] = true
+		}
+
+		for _, exp := range r.Manifest.Exports {
+			if !names[exp.Name] {
+				funcNames = append(funcNames, exp.Name)
+			}
+		}
+	}
+
+	errs := validateExports(module, funcNames)
+
 	_, _, err := r.GetInstance(module, engine, wasiConf)
+	if err != nil {
+		var vErr *ValidationError
+		if !errors.As(err, &vErr) {
+			return err
+		}
+
+		errs = append(errs, vErr.Errs...)
+	}
+
+	err = validationErr(errs)
 	if err != nil {
 		return err
 	}
 
-	r.GetRequiredExports(r.instance, r.store)
+	err = r.GetRequiredExports(r.instance, r.store)
+	if err != nil {
+		return err
+	}
 
-	r.FuncMap = make(map[string]*wasmtime.Func)
-	for i, _ := range funcNames {
-		fn := r.instance.GetExport(r.store, funcNames[i])
-		if fn != nil {
-			r.FuncMap[funcNames[i]] = r.instance.GetExport(r.store, funcNames[i]).Func()
+	err = r.setupMemory()
+	if err != nil {
+		return err
+	}
+
+	err = r.checkABI()
+	if err != nil {
+		return err
+	}
+
+	err = r.negotiateCodec()
+	if err != nil {
+		return err
+	}
+
+	// anything written while warming up is logged as call 0
+	if r.capture != nil {
+		_, err = r.capture.flush(0)
+		if err != nil {
+			return err
 		}
+	}
 
+	r.FuncMap = make(map[string]*wasmtime.Func)
+	for _, name := range funcNames {
+		r.FuncMap[name] = r.instance.GetFunc(r.store, name)
 	}
 
 	return nil
 }
 
-// Run will call a function in the WASM module
+// Run will call a function in the WASM module, errors reported by the guest
+// function are returned as a *GuestError
 func (r *Runner) Run(name string, args ...interface{}) (*shared_types.Payload, error) {
+	return r.RunContext(context.Background(), name, args...)
+}
+
+// RunContext will call a function in the WASM module and interrupt it if it is
+// still running when the ctx deadline passes, returning ErrDeadlineExceeded.
+// This needs an engine with epoch interruption enabled (see NewEngine).
+//
+// A ctx that is cancelled stops the guest at its next host function call and
+// returns context.Canceled, a guest that doesn't call the host can only be
+// stopped by a deadline. An instance that is interrupted, runs out of fuel or goes over its memory quota is discarded and
+// every later call returns ErrInstanceDiscarded.
+//
+// A Runner runs one call at a time, host functions that need to call back into the
+// guest get a HostCallContext (see WrapContextExport), calling RunContext from inside a
+// host function returns ErrReentrantCall.
+func (r *Runner) RunContext(ctx context.Context, name string, args ...interface{}) (*shared_types.Payload, error) {
+	if r.discarded {
+		return nil, ErrInstanceDiscarded
+	}
+
+	if r.depth > 0 {
+		return nil, ErrReentrantCall
+	}
+
 	fn, ok := r.FuncMap[name]
 	if !ok {
 		return nil, fmt.Errorf("function name not found")
 	}
 
-	out := &shared_types.Payload{}
+	if r.Manifest != nil {
+		err := r.Manifest.CheckArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := contextErr(ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		state := getEngineState(r.engine)
+		if state == nil || !state.cfg.EpochInterruption {
+			return nil, ErrEpochDisabled
+		}
+
+		state.ticker.acquire()
+		defer state.ticker.release()
+
+		r.store.SetEpochDeadline(state.ticker.deadlineTicks(deadline))
+		defer r.store.SetEpochDeadline(noEpochDeadline)
+	}
+
+	fuel := r.fuelEnabled()
+	var fuelBefore uint64
+	if fuel {
+		budget := r.FuelBudget
+		if budget == 0 {
+			budget = unlimitedFuel
+		}
+
+		err := r.setFuel(budget)
+		if err != nil {
+			return nil, err
+		}
+
+		fuelBefore, _ = r.store.FuelConsumed()
+	}
+
+	r.ctx = ctx
+	r.ctxDone = false
+	defer func() {
+		r.ctx = nil
+	}()
+
+	id := nextCallID()
+
+	out, err := r.call(ctx, name, fn, args...)
+
+	if err == nil && fuel {
+		fuelAfter, _ := r.store.FuelConsumed()
+		out.Stats = &shared_types.CallStats{FuelConsumed: fuelAfter - fuelBefore}
+	}
+
+	// the output of a failed call is still logged
+	if r.capture != nil {
+		output, cErr := r.capture.flush(id)
+		if err == nil && cErr != nil {
+			err = cErr
+		}
+
+		if err == nil && r.Capture.Return {
+			out.Output = output
+		}
+	}
 
-	err := ManagedCall(r.store, r.mem, r.inputBufferFn, r.outputBufferFn, fn, out, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -199,47 +554,130 @@ func (r *Runner) Run(name string, args ...interface{}) (*shared_types.Payload, e
 	return out, nil
 }
 
-// ManagedCall handles all the I/O for calling an exported WASM mmodule function by reading
-// and writing from the required WASM memory buffers and unmarshalling the output.
-func ManagedCall(store wasmtime.Storelike, mem *wasmtime.Memory, inputBufferFn *wasmtime.Func, outputBufferFn *wasmtime.Func, guestFn *wasmtime.Func, output *shared_types.Payload, args ...interface{}) error {
-	ptr, err := inputBufferFn.Call(store)
-	if err != nil {
-		return err
+// call runs the guest function fn and maps the ways it can fail to the errors
+// returned by RunContext, it is shared with the nested calls of a HostCallContext
+func (r *Runner) call(ctx context.Context, name string, fn *wasmtime.Func, args ...interface{}) (*shared_types.Payload, error) {
+	r.depth++
+	defer func() {
+		r.depth--
+	}()
+
+	out := &shared_types.Payload{}
+
+	err := ManagedCall(r.store, r.mem, r.bufs, r.wireCodec(), fn, out, args...)
+
+	// modules that weren't capped with CompileModule can grow past the memory
+	// quota, that can only be detected once control is back with the host
+	if memErr := r.checkMemory(); memErr != nil {
+		r.discarded = true
+		return nil, memErr
 	}
 
-	outPtr, err := outputBufferFn.Call(store)
 	if err != nil {
-		return err
+		if isOutOfFuel(err) {
+			r.discarded = true
+			return nil, ErrFuelExhausted
+		}
+
+		if r.ctxDone {
+			r.discarded = true
+			return nil, contextErr(ctx.Err())
+		}
+
+		if isInterrupt(err) {
+			r.discarded = true
+
+			// the epoch can tick past a deadline slightly before the ctx timer fires
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil, context.Canceled
+			}
+
+			return nil, ErrDeadlineExceeded
+		}
+
+		return nil, err
 	}
 
-	stArgs := &shared_types.Args{
-		Args: args,
+	if err := contextErr(ctx.Err()); err != nil {
+		return nil, err
 	}
 
-	enc, err := stArgs.MarshalMsg(nil)
-	if err != nil {
-		return err
+	if out.Error != nil {
+		return nil, newGuestError(name, out.Error)
 	}
 
-	inputLen := copy(mem.UnsafeData(store)[int(ptr.(int32)):int(ptr.(int32))+len(enc)], enc)
+	return out, nil
+}
+
+// Discarded reports whether the instance was discarded after an interrupted call
+func (r *Runner) Discarded() bool {
+	return r.discarded
+}
+
+// ManagedCall handles all the I/O for calling an exported WASM mmodule function by reading
+// and writing from the required WASM memory buffers and unmarshalling the output. Guests
+// with the allocator ABI get a buffer of exactly the size of the args, see GuestBuffers.
+//
+// An empty buffer stands for an empty envelope in both directions: a call without
+// args is made with an input length of 0, and a guest function that returns 0
+// (a nil result without meta) leaves output as an empty Payload. A nil arg is
+// still sent, so Run("fn", nil) passes a single nil argument.
+//
+// The envelopes are encoded with codec, nil is shared_types.Msgpack.
+func ManagedCall(store wasmtime.Storelike, mem *wasmtime.Memory, bufs *GuestBuffers, codec shared_types.Codec, guestFn *wasmtime.Func, output *shared_types.Payload, args ...interface{}) error {
+	codec = shared_types.OrDefault(codec)
+
+	var inputLen int32
+	if len(args) > 0 || bufs.LegacyEnvelopes {
+		stArgs := &shared_types.Args{
+			Args: args,
+		}
+
+		enc, err := codec.Marshal(stArgs)
+		if err != nil {
+			return err
+		}
+
+		inputLen, err = bufs.put(store, mem, bufs.Input, enc)
+		if err != nil {
+			return err
+		}
+	}
 
 	dataLen, err := guestFn.Call(store, inputLen)
 	if err != nil {
 		return err
 	}
 
-	outDat := make([]byte, dataLen.(int32))
-	copy(outDat[:], mem.UnsafeData(store)[int(outPtr.(int32)):int(outPtr.(int32))+int(dataLen.(int32))])
+	outLen, ok := dataLen.(int32)
+	if !ok {
+		return fmt.Errorf("%w: guest function returned %T as output length", ErrOutOfBounds, dataLen)
+	}
 
-	buf := bytes.NewBuffer(outDat)
-	err = msgp.Decode(buf, output)
+	if outLen == 0 {
+		return nil
+	}
+
+	outDat, err := bufs.get(store, mem, bufs.Output, outLen)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return codec.Unmarshal(outDat, output)
 }
 
+var (
+	defaultEngine     *wasmtime.Engine
+	defaultEngineOnce sync.Once
+)
+
+// GetEngine provides the engine with the default EngineConfig that is shared by
+// the whole process, deadlines passed to RunContext are enforced with epoch
+// interruption. Use NewEngine for an engine of your own.
 func GetEngine() *wasmtime.Engine {
-	return wasmtime.NewEngine()
+	defaultEngineOnce.Do(func() {
+		defaultEngine = NewEngine(nil)
+	})
+
+	return defaultEngine
 }
@@ -0,0 +1,546 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	"github.com/lonelycode/wasmy/interfaces"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// Buffer offsets of the test guest, these mirror the buffers the boilerplate in
+// module-params exports from a TinyGo module
+const (
+	testConstant         = 1024
+	testInputBuffer      = 0x10000
+	testOutputBuffer     = testInputBuffer + interfaces.FUNCBUFFER_SIZE
+	testHostInputBuffer  = testOutputBuffer + interfaces.FUNCBUFFER_SIZE
+	testHostOutputBuffer = testHostInputBuffer + interfaces.FUNCBUFFER_SIZE
+)
+
+// testGuestWAT is a hand written guest that implements the wasmy boilerplate:
+//   - constant: writes a pre-encoded Payload into the output buffer
+//   - callHost: passes its input to the `echo` host function and returns the host output
+//   - spin: never returns
+//   - spinHost: calls `echo` in a loop and never returns
+//   - badLength: reports an output length that overflows guest memory
+//   - grow: grows memory by 10 pages and then behaves like constant
+//   - growOrTrap: like grow, but traps when memory can't grow like an allocator would
+//   - empty: returns a nil result (an output length of 0)
+const testGuestWAT = `
+(module
+  (import "env" "main.echo" (func $echo (param i32 i32 i32) (result i32)))
+  (memory (export "memory") 100)
+  (data (i32.const %[1]d) "%[2]s")
+  (func (export "inputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[4]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[5]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[6]d))
+  (func (export "wasmy_abi_version") (result i32) (i32.const 2))
+  (func (export "constant") (param $len i32) (result i32)
+    (memory.copy (i32.const %[4]d) (i32.const %[1]d) (i32.const %[7]d))
+    (i32.const %[7]d))
+  (func (export "callHost") (param $len i32) (result i32)
+    (local $n i32)
+    (memory.copy (i32.const %[5]d) (i32.const %[3]d) (local.get $len))
+    (local.set $n (call $echo (local.get $len) (i32.const 0) (i32.const 0)))
+    (memory.copy (i32.const %[4]d) (i32.const %[6]d) (local.get $n))
+    (local.get $n))
+  (func (export "spin") (param $len i32) (result i32)
+    (loop $l (br $l))
+    (unreachable))
+  (func (export "spinHost") (param $len i32) (result i32)
+    (loop $l
+      (drop (call $echo (i32.const 0) (i32.const 0) (i32.const 0)))
+      (br $l))
+    (unreachable))
+  (func (export "badLength") (param $len i32) (result i32)
+    (i32.const 0x7fffffff))
+  (func (export "grow") (param $len i32) (result i32)
+    (drop (memory.grow (i32.const 10)))
+    (memory.copy (i32.const %[4]d) (i32.const %[1]d) (i32.const %[7]d))
+    (i32.const %[7]d))
+  (func (export "growOrTrap") (param $len i32) (result i32)
+    (if (i32.eq (memory.grow (i32.const 10)) (i32.const -1))
+      (then unreachable))
+    (memory.copy (i32.const %[4]d) (i32.const %[1]d) (i32.const %[7]d))
+    (i32.const %[7]d))
+  (func (export "empty") (param $len i32) (result i32)
+    (i32.const 0))
+)`
+
+// testModule compiles the test guest, its `constant` export returns the given payload
+func testModule(t testing.TB, engine *wasmtime.Engine, constant *shared_types.Payload) *wasmtime.Module {
+	t.Helper()
+
+	module, err := wasmtime.NewModule(engine, testWasm(t, constant))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return module
+}
+
+// testWasm assembles the test guest
+func testWasm(t testing.TB, constant *shared_types.Payload) []byte {
+	t.Helper()
+
+	enc, err := constant.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data strings.Builder
+	for _, b := range enc {
+		fmt.Fprintf(&data, "\\%02x", b)
+	}
+
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testGuestWAT,
+		testConstant, data.String(),
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer,
+		len(enc),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wasm
+}
+
+// echo is the host function imported by the test guest, it returns its first
+// argument or fails when that is "fail"
+func echo(args *shared_types.Args) (interface{}, error) {
+	if len(args.Args) == 0 {
+		return nil, nil
+	}
+
+	if args.Args[0] == "fail" {
+		return nil, errors.New("host says no")
+	}
+
+	return args.Args[0], nil
+}
+
+// testRunner warms up a Runner for the test guest
+func testRunner(t testing.TB, engine *wasmtime.Engine, module *wasmtime.Module) *Runner {
+	t.Helper()
+
+	r := &Runner{}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err := r.WarmUp(engine, module, nil, "constant", "callHost", "spin", "spinHost", "badLength", "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestRun(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	out, err := r.Run("constant", "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != "constant" {
+		t.Fatalf("expected constant payload, got %v", out.Data)
+	}
+
+	out, err = r.Run("callHost", "martin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != "martin" {
+		t.Fatalf("expected host echo, got %v", out.Data)
+	}
+
+	_, err = r.Run("missing")
+	if err == nil {
+		t.Fatal("expected an error for an unknown function")
+	}
+}
+
+func TestRunZeroArgs(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	var got *shared_types.Args
+	r := &Runner{}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(func(args *shared_types.Args) (interface{}, error) {
+			got = args
+			return echo(args)
+		}),
+	}
+
+	err := r.WarmUp(engine, module, nil, "constant", "callHost", "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// callHost passes its input through to echo and returns the host output, so
+	// these go through both the guest and the host side of the protocol
+	tests := []struct {
+		name string
+		args []interface{}
+		want []interface{}
+	}{
+		{"no args", nil, nil},
+		{"empty args", []interface{}{}, nil},
+		{"nil arg", []interface{}{nil}, []interface{}{nil}},
+		{"nil first arg", []interface{}{nil, "x"}, []interface{}{nil, "x"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			out, err := r.Run("callHost", tc.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out.Data != nil || out.Error != nil {
+				t.Errorf("expected an empty payload, got %+v", out)
+			}
+
+			if got == nil || len(got.Args) != len(tc.want) {
+				t.Fatalf("expected host args %v, got %+v", tc.want, got)
+			}
+
+			for i := range tc.want {
+				if got.Args[i] != tc.want[i] {
+					t.Errorf("expected host args %v, got %v", tc.want, got.Args)
+				}
+			}
+		})
+	}
+
+	out, err := r.Run("constant")
+	if err != nil || out.Data != "constant" {
+		t.Errorf("expected constant without args, got %+v, %v", out, err)
+	}
+
+	out, err = r.Run("empty", "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != nil || out.Meta != nil || out.Error != nil {
+		t.Errorf("expected an empty payload for a nil result, got %+v", out)
+	}
+}
+
+func TestRunBounds(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	_, err := r.Run("constant", strings.Repeat("x", interfaces.FUNCBUFFER_SIZE))
+	if !errors.Is(err, ErrPayloadTooLarge) || !errors.Is(err, interfaces.ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+
+	_, err = r.Run("badLength", "x")
+	if !errors.Is(err, ErrPayloadTooLarge) && !errors.Is(err, ErrOutOfBounds) {
+		t.Fatalf("expected a bounds error, got %v", err)
+	}
+
+	// the instance is still usable after rejecting a call
+	out, err := r.Run("constant", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Data != "constant" {
+		t.Fatalf("expected constant payload, got %v", out.Data)
+	}
+}
+
+func TestRunContextDeadline(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.RunContext(ctx, "spin", "x")
+	if !errors.Is(err, ErrDeadlineExceeded) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrDeadlineExceeded, got %v", err)
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("interrupt took %s", took)
+	}
+
+	if !r.Discarded() {
+		t.Fatal("expected the interrupted instance to be discarded")
+	}
+
+	_, err = r.Run("constant", "x")
+	if !errors.Is(err, ErrInstanceDiscarded) {
+		t.Fatalf("expected ErrInstanceDiscarded, got %v", err)
+	}
+
+	// a deadline that is not hit leaves the instance usable
+	r = testRunner(t, engine, module)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = r.RunContext(ctx, "constant", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = r.RunContext(cancelled, "constant", "x")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	// the guest is stopped at its next host call
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := r.RunContext(ctx, "spinHost", "x")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("cancel took %s", took)
+	}
+
+	if !r.Discarded() {
+		t.Fatal("expected the cancelled instance to be discarded")
+	}
+
+	// a ctx that is cancelled after the call leaves the instance usable
+	r = testRunner(t, engine, module)
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = r.RunContext(ctx, "callHost", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	_, err = r.Run("callHost", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseEngine(t *testing.T) {
+	engine := NewEngine(nil)
+	if getEngineState(engine) == nil {
+		t.Fatal("expected engine state")
+	}
+
+	ReleaseEngine(engine)
+	if getEngineState(engine) != nil {
+		t.Fatal("expected the engine state to be dropped")
+	}
+
+	// the shared engine stays usable
+	if GetEngine() != GetEngine() {
+		t.Fatal("expected GetEngine to return the shared engine")
+	}
+
+	ReleaseEngine(GetEngine())
+	if getEngineState(GetEngine()) == nil {
+		t.Fatal("expected the shared engine to keep its state")
+	}
+}
+
+func TestRunContextEpochDisabled(t *testing.T) {
+	engine := NewEngine(&EngineConfig{})
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := r.RunContext(ctx, "constant", "x")
+	if !errors.Is(err, ErrEpochDisabled) {
+		t.Fatalf("expected ErrEpochDisabled, got %v", err)
+	}
+
+	_, err = r.Run("constant", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunFuel(t *testing.T) {
+	engine := NewEngine(&EngineConfig{ConsumeFuel: true})
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	r := &Runner{FuelBudget: 100000}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err := r.WarmUp(engine, module, nil, "constant", "spin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		out, err := r.Run("constant", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if out.Stats == nil || out.Stats.FuelConsumed == 0 {
+			t.Fatalf("expected fuel consumption to be reported, got %+v", out.Stats)
+		}
+	}
+
+	_, err = r.Run("spin", "x")
+	if !errors.Is(err, ErrFuelExhausted) {
+		t.Fatalf("expected ErrFuelExhausted, got %v", err)
+	}
+
+	if !r.Discarded() {
+		t.Fatal("expected the instance to be discarded")
+	}
+
+	r = &Runner{FuelBudget: 100}
+	err = r.WarmUp(GetEngine(), module, nil)
+	if !errors.Is(err, ErrFuelDisabled) {
+		t.Fatalf("expected ErrFuelDisabled, got %v", err)
+	}
+}
+
+func TestRunMemoryLimit(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+
+	r := &Runner{Config: &RunnerConfig{InitialPages: 102, MaxPages: 115}}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err := r.WarmUp(engine, module, nil, "grow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := r.MemoryUsage()
+	if usage.Pages != 102 || usage.Bytes != 102*PageSize || usage.MaxPages != 115 {
+		t.Fatalf("unexpected memory usage %+v", usage)
+	}
+
+	_, err = r.Run("grow", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Run("grow", "x")
+	if !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+
+	if !r.Discarded() {
+		t.Fatal("expected the instance to be discarded")
+	}
+
+	r = &Runner{Config: &RunnerConfig{MinPages: 120, MaxPages: 115}}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err = r.WarmUp(engine, module, nil)
+	if !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+}
+
+func TestRunGuestError(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Error: &shared_types.Error{
+		Code:    "not_found",
+		Message: "no such user",
+		Details: map[string]string{"user": "martin"},
+	}})
+	r := testRunner(t, engine, module)
+
+	_, err := r.Run("constant", "martin")
+
+	var gErr *GuestError
+	if !errors.As(err, &gErr) {
+		t.Fatalf("expected a GuestError, got %v", err)
+	}
+
+	if gErr.Function != "constant" || gErr.Code != "not_found" || gErr.Message != "no such user" || gErr.Details["user"] != "martin" {
+		t.Fatalf("unexpected guest error %+v", gErr)
+	}
+}
+
+func TestHostFunctionError(t *testing.T) {
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	// callHost hands the host output straight back, so the host error envelope
+	// comes back as if the guest had returned it
+	_, err := r.Run("callHost", "fail")
+
+	var gErr *GuestError
+	if !errors.As(err, &gErr) {
+		t.Fatalf("expected a GuestError, got %v", err)
+	}
+
+	if gErr.Code != shared_types.ErrCodeHost || gErr.Message != "host says no" {
+		t.Fatalf("unexpected host error %+v", gErr)
+	}
+}
+
+func TestCall(t *testing.T) {
+	type user struct {
+		Name string   `msg:"name"`
+		Age  int      `msg:"age"`
+		Tags []string `msg:"tags"`
+	}
+
+	engine := GetEngine()
+	module := testModule(t, engine, &shared_types.Payload{Data: "constant"})
+	r := testRunner(t, engine, module)
+
+	in := user{Name: "martin", Age: 42, Tags: []string{"admin"}}
+	out, err := Call[user, user](r, "callHost", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Name != in.Name || out.Age != in.Age || len(out.Tags) != 1 || out.Tags[0] != "admin" {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+
+	_, err = Call[user, int](r, "callHost", in)
+	if err == nil {
+		t.Fatal("expected a decode error")
+	}
+}
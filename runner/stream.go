@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/lonelycode/wasmy/interfaces"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// maxEmptyReads is how many times in a row the stream input may return no data
+// and no error before the read fails with io.ErrNoProgress, same as bufio
+const maxEmptyReads = 100
+
+// stream is the input and output of the streaming call in progress
+type stream struct {
+	in  io.Reader
+	out io.Writer
+	// err is an input error that came with the last chunk
+	err error
+}
+
+// Stream calls the guest function name like RunContext while the guest reads in
+// and writes to out through the `wasmy:stream` imports (see interfaces.StreamReader
+// and interfaces.StreamWriter). Chunks are moved one at a time when the guest asks
+// for them, so the guest is blocked while out is busy and in is only read as fast
+// as the guest consumes it. The Payload returned by the guest function is the final
+// status of the call.
+//
+// A nil in is an empty input, a guest writing to a nil out gets ErrNoStreamOutput.
+func (r *Runner) Stream(ctx context.Context, name string, in io.Reader, out io.Writer, args ...interface{}) (*shared_types.Payload, error) {
+	r.stream = &stream{in: in, out: out}
+	defer func() {
+		r.stream = nil
+	}()
+
+	return r.RunContext(ctx, name, args...)
+}
+
+// streamModule provides the `wasmy:stream` imports, every Runner links it
+func (r *Runner) streamModule() *HostModule {
+	m := NewHostModule(interfaces.StreamModule)
+	m.Define("read", r.streamRead)
+	m.Define("write", r.streamWrite)
+
+	return m
+}
+
+// streamRead returns the next chunk of the stream input, the guest passes the
+// most it wants to read. A nil result is the end of the input, an input that keeps
+// returning nothing fails the read with io.ErrNoProgress.
+func (r *Runner) streamRead(args *shared_types.Args) (interface{}, error) {
+	if r.stream == nil || r.stream.in == nil {
+		return nil, nil
+	}
+
+	want := interfaces.StreamChunkSize
+	if len(args.Args) > 0 {
+		var n int
+		err := shared_types.FromWire(args.Args[0], &n)
+		if err != nil {
+			return nil, err
+		}
+
+		if n > 0 && n < want {
+			want = n
+		}
+	}
+
+	buf := make([]byte, want)
+	for i := 0; i < maxEmptyReads; i++ {
+		n, err := 0, r.stream.err
+		if err == nil {
+			n, err = r.stream.in.Read(buf)
+		}
+
+		if n > 0 {
+			// the guest gets the error with the next read
+			r.stream.err = err
+			return buf[:n], nil
+		}
+
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream input: %w", err)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to read stream input: %w", io.ErrNoProgress)
+}
+
+// streamWrite passes a chunk of the stream output on to the writer
+func (r *Runner) streamWrite(args *shared_types.Args) (interface{}, error) {
+	if r.stream == nil || r.stream.out == nil {
+		return nil, ErrNoStreamOutput
+	}
+
+	var chunk []byte
+	if len(args.Args) > 0 {
+		err := shared_types.FromWire(args.Args[0], &chunk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := r.stream.out.Write(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write stream output: %w", err)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,160 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// testStreamGuestWAT is a streaming guest, `pump` reads its input in chunks of at
+// most 4 bytes and writes "hello" for every chunk it gets, then returns "done". The
+// read and write args are pre-encoded, the guest doesn't look at the chunks.
+const testStreamGuestWAT = `
+(module
+  (import "wasmy:stream" "read" (func $read (param i32) (result i32)))
+  (import "wasmy:stream" "write" (func $write (param i32) (result i32)))
+  (memory (export "memory") 100)
+  (data (i32.const 1024) "%[5]s")
+  (data (i32.const 1200) "%[6]s")
+  (data (i32.const 1400) "%[7]s")
+  (func (export "inputBuffer") (result i32) (i32.const %[1]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[2]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[4]d))
+  (func (export "wasmy_abi_version") (result i32) (i32.const 2))
+  (func (export "pump") (param $len i32) (result i32)
+    (block $done
+      (loop $next
+        (memory.copy (i32.const %[3]d) (i32.const 1024) (i32.const %[8]d))
+        (br_if $done (i32.eqz (call $read (i32.const %[8]d))))
+        (memory.copy (i32.const %[3]d) (i32.const 1200) (i32.const %[9]d))
+        (drop (call $write (i32.const %[9]d)))
+        (br $next)))
+    (memory.copy (i32.const %[2]d) (i32.const 1400) (i32.const %[10]d))
+    (i32.const %[10]d))
+)`
+
+func TestStream(t *testing.T) {
+	enc := func(m interface{ MarshalMsg([]byte) ([]byte, error) }) (string, int) {
+		b, err := m.MarshalMsg(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var data strings.Builder
+		for _, c := range b {
+			fmt.Fprintf(&data, "\\%02x", c)
+		}
+
+		return data.String(), len(b)
+	}
+
+	readArgs, readLen := enc(&shared_types.Args{Args: []interface{}{4}})
+	writeArgs, writeLen := enc(&shared_types.Args{Args: []interface{}{[]byte("hello")}})
+	done, doneLen := enc(&shared_types.Payload{Data: "done"})
+
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testStreamGuestWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer,
+		readArgs, writeArgs, done, readLen, writeLen, doneLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{}
+	err = r.WarmUp(engine, module, nil, "pump")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one byte at a time from the reader still gives the guest a chunk per read
+	var out bytes.Buffer
+	status, err := r.Stream(context.Background(), "pump", iotest.OneByteReader(strings.NewReader("abcdefghij")), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Data != "done" {
+		t.Errorf("expected the final status, got %+v", status)
+	}
+
+	if out.String() != strings.Repeat("hello", 10) {
+		t.Errorf("expected a hello per chunk, got %q", out.String())
+	}
+
+	out.Reset()
+	_, err = r.Stream(context.Background(), "pump", strings.NewReader("abcdefghij"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != strings.Repeat("hello", 3) {
+		t.Errorf("expected 3 chunks of at most 4 bytes, got %q", out.String())
+	}
+
+	// outside of Stream the input is empty
+	status, err = r.Run("pump")
+	if err != nil || status.Data != "done" {
+		t.Errorf("expected an empty stream, got %+v, %v", status, err)
+	}
+}
+
+func TestStreamHostFuncs(t *testing.T) {
+	r := &Runner{}
+
+	_, err := r.streamWrite(&shared_types.Args{Args: []interface{}{[]byte("x")}})
+	if !errors.Is(err, ErrNoStreamOutput) {
+		t.Fatalf("expected ErrNoStreamOutput, got %v", err)
+	}
+
+	// an error that comes with the last chunk is returned by the next read
+	boom := errors.New("boom")
+	r.stream = &stream{in: iotest.DataErrReader(io.MultiReader(strings.NewReader("ab"), iotest.ErrReader(boom)))}
+	ret, err := r.streamRead(&shared_types.Args{})
+	if err != nil || string(ret.([]byte)) != "ab" {
+		t.Fatalf("expected the last chunk, got %v, %v", ret, err)
+	}
+
+	_, err = r.streamRead(&shared_types.Args{})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the reader error, got %v", err)
+	}
+
+	r.stream = &stream{in: iotest.DataErrReader(strings.NewReader("abc"))}
+	ret, err = r.streamRead(&shared_types.Args{Args: []interface{}{int64(8)}})
+	if err != nil || string(ret.([]byte)) != "abc" {
+		t.Fatalf("expected the last chunk, got %v, %v", ret, err)
+	}
+
+	ret, err = r.streamRead(&shared_types.Args{Args: []interface{}{int64(8)}})
+	if err != nil || ret != nil {
+		t.Fatalf("expected the end of the input, got %v, %v", ret, err)
+	}
+
+	// a reader that never returns anything doesn't hang the guest
+	r.stream = &stream{in: emptyReader{}}
+	_, err = r.streamRead(&shared_types.Args{})
+	if !errors.Is(err, io.ErrNoProgress) {
+		t.Fatalf("expected io.ErrNoProgress, got %v", err)
+	}
+}
+
+// emptyReader returns no data and no error
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) {
+	return 0, nil
+}
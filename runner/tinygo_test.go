@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestGuestPackagesBuild cross-compiles the guest packages and the example guest
+// for wasip1 with the tinygo build tag, so code that only TinyGo builds doesn't go
+// unchecked when tinygo isn't installed
+func TestGuestPackagesBuild(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	out := filepath.Join(t.TempDir(), "managedv2.wasm")
+	for _, args := range [][]string{
+		{"build", "-tags", "tinygo", "../module-params/...", "../interfaces/...", "../shared-types/..."},
+		{"build", "-tags", "tinygo", "-o", out, "../wasm-tests/managedv2.go"},
+	} {
+		cmd := exec.Command(goBin, args...)
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if log, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("guest build failed: %v\n%s", err, log)
+		}
+	}
+}
+
+// TestTinyGoGuest builds the example guest with TinyGo, everything module-params
+// imports (shared-types and its codecs included) has to compile there. It is
+// skipped when tinygo is not installed.
+func TestTinyGoGuest(t *testing.T) {
+	tinygo, err := exec.LookPath("tinygo")
+	if err != nil {
+		t.Skip("tinygo is not installed")
+	}
+
+	out := filepath.Join(t.TempDir(), "managedv2.wasm")
+	cmd := exec.Command(tinygo, "build", "-o", out, "-target=wasi", "../wasm-tests/managedv2.go")
+	if log, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("tinygo build failed: %v\n%s", err, log)
+	}
+
+	module, err := GetModule(out, GetEngine())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := validateExports(module, []string{"myExport"}); len(errs) > 0 {
+		t.Fatal(validationErr(errs))
+	}
+}
@@ -0,0 +1,207 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+// requiredBufferExports are the boilerplate exports provided by module-params,
+// each returns a pointer to one of the managed I/O buffers
+var requiredBufferExports = []string{"inputBuffer", "outputBuffer", "hostInputBuffer", "hostOutputBuffer"}
+
+// The optional allocator ABI, see GuestBuffers
+const (
+	allocExport = "wasmy_alloc"
+	freeExport  = "wasmy_free"
+)
+
+// ValidationError lists every problem WarmUp found with a module, errors.Is and
+// errors.As match against each of them
+type ValidationError struct {
+	Errs []error
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("module validation failed:")
+	for _, err := range e.Errs {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+
+	return b.String()
+}
+
+func (e *ValidationError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *ValidationError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validationErr returns errs as a *ValidationError, or nil if there are none
+func validationErr(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Errs: errs}
+}
+
+// validateExports checks that module carries the wasmy boilerplate, that it has
+// all of the allocator ABI if it has any of it, that its version and codec exports
+// are well typed if it has them, and that it exports every function in funcNames
+// as a managed (i32) -> i32 function.
+func validateExports(module *wasmtime.Module, funcNames []string) []error {
+	exports := make(map[string]*wasmtime.ExternType)
+	for _, exp := range module.Exports() {
+		exports[exp.Name()] = exp.Type()
+	}
+
+	var errs []error
+
+	if ty, ok := exports["memory"]; !ok {
+		errs = append(errs, fmt.Errorf("%w: memory", ErrMissingExport))
+	} else if ty.MemoryType() == nil {
+		errs = append(errs, fmt.Errorf("%w: memory is not a memory", ErrExportSignature))
+	}
+
+	for _, name := range requiredBufferExports {
+		errs = append(errs, checkFuncExport(exports, name, nil, []wasmtime.ValKind{wasmtime.KindI32})...)
+	}
+
+	_, hasAlloc := exports[allocExport]
+	_, hasFree := exports[freeExport]
+	if hasAlloc || hasFree {
+		errs = append(errs, checkFuncExport(exports, allocExport, []wasmtime.ValKind{wasmtime.KindI32}, []wasmtime.ValKind{wasmtime.KindI32})...)
+		errs = append(errs, checkFuncExport(exports, freeExport, []wasmtime.ValKind{wasmtime.KindI32}, nil)...)
+	}
+
+	if _, ok := exports[abiVersionExport]; ok {
+		errs = append(errs, checkFuncExport(exports, abiVersionExport, nil, []wasmtime.ValKind{wasmtime.KindI32})...)
+	}
+
+	if _, ok := exports[codecExport]; ok {
+		errs = append(errs, checkFuncExport(exports, codecExport, []wasmtime.ValKind{wasmtime.KindI32}, []wasmtime.ValKind{wasmtime.KindI32})...)
+	}
+
+	for _, name := range funcNames {
+		errs = append(errs, checkFuncExport(exports, name, []wasmtime.ValKind{wasmtime.KindI32}, []wasmtime.ValKind{wasmtime.KindI32})...)
+	}
+
+	return errs
+}
+
+func checkFuncExport(exports map[string]*wasmtime.ExternType, name string, params, results []wasmtime.ValKind) []error {
+	ty, ok := exports[name]
+	if !ok {
+		return []error{fmt.Errorf("%w: %s", ErrMissingExport, name)}
+	}
+
+	fnType := ty.FuncType()
+	if fnType == nil {
+		return []error{fmt.Errorf("%w: %s is not a function", ErrExportSignature, name)}
+	}
+
+	if !kindsEqual(fnType.Params(), params) || !kindsEqual(fnType.Results(), results) {
+		return []error{fmt.Errorf("%w: %s is %s, want %s", ErrExportSignature, name, funcSig(fnType.Params(), fnType.Results()), kindSig(params, results))}
+	}
+
+	return nil
+}
+
+// unresolvedImports checks that every import of module is defined in linker with a
+// matching type, imports that linkable says were left out on purpose are reported
+// by the manifest or policy instead
+func unresolvedImports(linker *wasmtime.Linker, store wasmtime.Storelike, module *wasmtime.Module, linkable func(module, name string) bool) []error {
+	var errs []error
+	for _, imp := range module.Imports() {
+		name := ""
+		if imp.Name() != nil {
+			name = *imp.Name()
+		}
+
+		if !linkable(imp.Module(), name) {
+			continue
+		}
+
+		ext := linker.Get(store, imp.Module(), name)
+		if ext == nil {
+			errs = append(errs, fmt.Errorf("%w: %s/%s", ErrUnresolvedImport, imp.Module(), name))
+			continue
+		}
+
+		want := imp.Type().FuncType()
+		if want == nil || ext.Func() == nil {
+			continue
+		}
+
+		got := ext.Func().Type(store)
+		if !sameKinds(got.Params(), want.Params()) || !sameKinds(got.Results(), want.Results()) {
+			errs = append(errs, fmt.Errorf("%w: %s/%s is imported as %s but the host defines %s", ErrImportSignature,
+				imp.Module(), name, funcSig(want.Params(), want.Results()), funcSig(got.Params(), got.Results())))
+		}
+	}
+
+	return errs
+}
+
+func kindsEqual(types []*wasmtime.ValType, kinds []wasmtime.ValKind) bool {
+	if len(types) != len(kinds) {
+		return false
+	}
+
+	for i := range types {
+		if types[i].Kind() != kinds[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sameKinds(a, b []*wasmtime.ValType) bool {
+	return kindsEqual(a, valKinds(b))
+}
+
+func funcSig(params, results []*wasmtime.ValType) string {
+	return kindSig(valKinds(params), valKinds(results))
+}
+
+func valKinds(types []*wasmtime.ValType) []wasmtime.ValKind {
+	kinds := make([]wasmtime.ValKind, len(types))
+	for i := range types {
+		kinds[i] = types[i].Kind()
+	}
+
+	return kinds
+}
+
+// kindSig formats a signature like `(i32, i32) -> (i32)`
+func kindSig(params, results []wasmtime.ValKind) string {
+	join := func(kinds []wasmtime.ValKind) string {
+		s := make([]string, len(kinds))
+		for i := range kinds {
+			s[i] = kinds[i].String()
+		}
+		return strings.Join(s, ", ")
+	}
+
+	return fmt.Sprintf("(%s) -> (%s)", join(params), join(results))
+}
@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+func TestWarmUpValidation(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(`
+(module
+  (import "env" "main.missing" (func (param i32 i32 i32) (result i32)))
+  (import "env" "main.echo" (func (param i32) (result i32)))
+  (memory (export "memory") 1)
+  (func (export "inputBuffer") (result i32) (i32.const 0))
+  (func (export "outputBuffer") (param i32) (result i32) (i32.const 0))
+  (func (export "hostInputBuffer") (result i32) (i32.const 0))
+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{}
+	r.HostFunctions = map[string]ExportFunc{
+		"echo": r.WrapExport(echo),
+	}
+
+	err = r.WarmUp(engine, module, nil, "myExport")
+
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	for _, want := range []string{
+		"wrong signature: outputBuffer is (i32) -> (i32), want () -> (i32)",
+		"missing export: hostOutputBuffer",
+		"missing export: myExport",
+		"unresolved import: env/main.missing",
+		"wrong signature: env/main.echo is imported as (i32) -> (i32) but the host defines (i32, i32, i32) -> (i32)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%s", want, err)
+		}
+	}
+
+	if len(vErr.Errs) != 5 {
+		t.Errorf("expected 5 problems, got %d:\n%s", len(vErr.Errs), err)
+	}
+
+	for _, sentinel := range []error{ErrMissingExport, ErrExportSignature, ErrUnresolvedImport, ErrImportSignature} {
+		if !errors.Is(err, sentinel) {
+			t.Errorf("expected the error to match %v", sentinel)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+)
+
+// DirAccess is what a guest may do in a preopened dir
+type DirAccess int
+
+const (
+	// ReadOnly lets the guest list, stat and read files
+	ReadOnly DirAccess = iota
+	// ReadWrite also lets the guest create, change and remove files
+	ReadWrite
+)
+
+// PreopenDir is a host dir the guest can see at GuestPath
+type PreopenDir struct {
+	HostPath  string
+	GuestPath string
+	Access    DirAccess
+}
+
+// WASIOptions builds the WASI config of every instance of a Runner, on top of
+// the defaults of GetInstance: stdout and stderr stay shared with the host (or
+// captured with Runner.Capture) whatever the options say. Build them with the
+// methods or start from one of the presets, and don't change them once a Runner
+// is using them.
+type WASIOptions struct {
+	dirs       []PreopenDir
+	envKeys    []string
+	envValues  []string
+	inheritEnv bool
+	args       []string
+	inheritArg bool
+
+	stdin        io.Reader
+	inheritStdin bool
+	stdinOnce    sync.Once
+	stdinData    []byte
+	stdinErr     error
+}
+
+// NewWASIOptions gives the guest nothing but stdout and stderr, same as Sandboxed
+func NewWASIOptions() *WASIOptions {
+	return &WASIOptions{}
+}
+
+// Sandboxed gives the guest no files, no environment, no args and no stdin
+func Sandboxed() *WASIOptions {
+	return NewWASIOptions()
+}
+
+// ReadOnlyFS is Sandboxed with every dir preopened read-only at the same path in
+// the guest
+func ReadOnlyFS(dirs ...string) *WASIOptions {
+	o := NewWASIOptions()
+	for _, dir := range dirs {
+		o.Dir(dir, dir, ReadOnly)
+	}
+
+	return o
+}
+
+// DevInherit shares the environment, args, stdin and working dir (read-write, as
+// ".") of the host with the guest, this is handy during development but gives a
+// plugin the same access as the host process
+func DevInherit() *WASIOptions {
+	return NewWASIOptions().InheritEnv().InheritArgs().InheritStdin().Dir(".", ".", ReadWrite)
+}
+
+// Dir preopens hostPath for the guest at guestPath
+func (o *WASIOptions) Dir(hostPath, guestPath string, access DirAccess) *WASIOptions {
+	o.dirs = append(o.dirs, PreopenDir{HostPath: hostPath, GuestPath: guestPath, Access: access})
+	return o
+}
+
+// Env sets an environment variable in the guest
+func (o *WASIOptions) Env(key, value string) *WASIOptions {
+	o.envKeys = append(o.envKeys, key)
+	o.envValues = append(o.envValues, value)
+	return o
+}
+
+// AllowEnv passes the named environment variables of the host on to the guest,
+// variables that aren't set on the host are left out
+func (o *WASIOptions) AllowEnv(keys ...string) *WASIOptions {
+	for _, key := range keys {
+		if v, ok := os.LookupEnv(key); ok {
+			o.Env(key, v)
+		}
+	}
+
+	return o
+}
+
+// InheritEnv passes the whole environment of the host on to the guest, it
+// replaces Env and AllowEnv
+func (o *WASIOptions) InheritEnv() *WASIOptions {
+	o.inheritEnv = true
+	return o
+}
+
+// Args sets the args of the guest, by convention the first one is the program name
+func (o *WASIOptions) Args(args ...string) *WASIOptions {
+	o.args = append(o.args, args...)
+	return o
+}
+
+// InheritArgs passes the args of the host on to the guest, it replaces Args
+func (o *WASIOptions) InheritArgs() *WASIOptions {
+	o.inheritArg = true
+	return o
+}
+
+// Stdin feeds r to the guest as stdin. It is read to the end the first time an
+// instance is created, and every instance of the Runner (or Pool) gets the same
+// input.
+func (o *WASIOptions) Stdin(r io.Reader) *WASIOptions {
+	o.stdin = r
+	return o
+}
+
+// InheritStdin shares the stdin of the host with the guest, it replaces Stdin
+func (o *WASIOptions) InheritStdin() *WASIOptions {
+	o.inheritStdin = true
+	return o
+}
+
+// config creates the WASI config of a new instance, a nil WASIOptions gives the
+// defaults
+func (o *WASIOptions) config() (*wasmtime.WasiConfig, error) {
+	conf := wasmtime.NewWasiConfig()
+	conf.InheritStdout()
+	conf.InheritStderr()
+
+	if o == nil {
+		return conf, nil
+	}
+
+	for _, dir := range o.dirs {
+		err := conf.PreopenDir(dir.HostPath, dir.GuestPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to preopen %q: %w", dir.HostPath, err)
+		}
+	}
+
+	if o.inheritEnv {
+		conf.InheritEnv()
+	} else if len(o.envKeys) > 0 {
+		conf.SetEnv(o.envKeys, o.envValues)
+	}
+
+	if o.inheritArg {
+		conf.InheritArgv()
+	} else if len(o.args) > 0 {
+		conf.SetArgv(o.args)
+	}
+
+	if o.inheritStdin {
+		conf.InheritStdin()
+	} else if o.stdin != nil {
+		err := o.setStdin(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return conf, nil
+}
+
+// setStdin spools the stdin reader to an unlinked temp file for the guest to read
+func (o *WASIOptions) setStdin(conf *wasmtime.WasiConfig) error {
+	o.stdinOnce.Do(func() {
+		o.stdinData, o.stdinErr = io.ReadAll(o.stdin)
+	})
+	if o.stdinErr != nil {
+		return fmt.Errorf("failed to read guest stdin: %w", o.stdinErr)
+	}
+
+	f, err := os.CreateTemp("", "wasmy-input-*")
+	if err != nil {
+		return fmt.Errorf("failed to spool guest stdin: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.Write(o.stdinData)
+	if err != nil {
+		return fmt.Errorf("failed to spool guest stdin: %w", err)
+	}
+
+	return conf.SetStdinFile(f.Name())
+}
+
+// WASI rights (wasi_snapshot_preview1) a read-only dir keeps
+const (
+	rightFdRead          = 1 << 1
+	rightFdSeek          = 1 << 2
+	rightFdTell          = 1 << 5
+	rightFdAdvise        = 1 << 7
+	rightPathOpen        = 1 << 13
+	rightFdReaddir       = 1 << 14
+	rightPathReadlink    = 1 << 15
+	rightPathFilestatGet = 1 << 18
+	rightFdFilestatGet   = 1 << 21
+	rightPollFdReadwrite = 1 << 27
+
+	readOnlyRights = rightFdRead | rightFdSeek | rightFdTell | rightFdAdvise | rightPathOpen |
+		rightFdReaddir | rightPathReadlink | rightPathFilestatGet | rightFdFilestatGet | rightPollFdReadwrite
+)
+
+// WASI fds of the standard streams and of the first preopened dir
+const (
+	wasiStdout     = 1
+	wasiStderr     = 2
+	firstPreopenFd = 3
+)
+
+// wasiHelperWAT calls WASI functions for the host, they can only be called from
+// an instance with a memory. rewind seeks an fd back to the start.
+const wasiHelperWAT = `
+(module
+  (import "wasi_snapshot_preview1" "fd_fdstat_set_rights" (func $set_rights (param i32 i64 i64) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_seek" (func $seek (param i32 i64 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (func (export "restrict") (param i32 i64 i64) (result i32)
+    (call $set_rights (local.get 0) (local.get 1) (local.get 2)))
+  (func (export "rewind") (param i32) (result i32)
+    (call $seek (local.get 0) (i64.const 0) (i32.const 0) (i32.const 0)))
+)`
+
+// wasiHelper is an instance of wasiHelperWAT in the store of an instance, it is
+// only created once something needs it
+type wasiHelper struct {
+	engine   *wasmtime.Engine
+	linker   *wasmtime.Linker
+	store    *wasmtime.Store
+	instance *wasmtime.Instance
+}
+
+// call calls the WASI helper function name, a non-zero errno is an error
+func (h *wasiHelper) call(name string, args ...interface{}) error {
+	if h.instance == nil {
+		module, err := wasiHelperModule(h.engine)
+		if err != nil {
+			return err
+		}
+
+		h.instance, err = h.linker.Instantiate(h.store, module)
+		if err != nil {
+			return err
+		}
+	}
+
+	errno, err := h.instance.GetFunc(h.store, name).Call(h.store, args...)
+	if err != nil {
+		return err
+	}
+
+	if errno != int32(0) {
+		return fmt.Errorf("WASI errno %v", errno)
+	}
+
+	return nil
+}
+
+// wasiHelperModule compiles wasiHelperWAT once per engine created with NewEngine,
+// for other engines it is compiled every time
+func wasiHelperModule(engine *wasmtime.Engine) (*wasmtime.Module, error) {
+	state := getEngineState(engine)
+	if state == nil {
+		return compileWASIHelper(engine)
+	}
+
+	state.helperOnce.Do(func() {
+		state.helper, state.helperErr = compileWASIHelper(engine)
+	})
+
+	return state.helper, state.helperErr
+}
+
+func compileWASIHelper(engine *wasmtime.Engine) (*wasmtime.Module, error) {
+	wasm, err := wasmtime.Wat2Wasm(wasiHelperWAT)
+	if err != nil {
+		return nil, err
+	}
+
+	return wasmtime.NewModule(engine, wasm)
+}
+
+// restrict drops the write rights of the read-only dirs of a new instance. The
+// wasmtime-go bindings can't preopen a dir read-only, so the helper instance asks
+// WASI to drop them before the guest gets to run, files and dirs opened below a
+// dir can never get more rights than the dir has.
+func (o *WASIOptions) restrict(helper *wasiHelper) error {
+	if o == nil {
+		return nil
+	}
+
+	for i, dir := range o.dirs {
+		if dir.Access != ReadOnly {
+			continue
+		}
+
+		err := helper.call("restrict", int32(firstPreopenFd+i), int64(readOnlyRights), int64(readOnlyRights))
+		if err != nil {
+			return fmt.Errorf("failed to make %q read-only: %w", dir.HostPath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go"
+	shared_types "github.com/lonelycode/wasmy/shared-types"
+)
+
+// testWASIGuestWAT pokes at the WASI access it was given, every export returns
+// a WASI errno or a count
+const testWASIGuestWAT = `
+(module
+  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_create_directory" (func $mkdir (param i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "args_sizes_get" (func $args_sizes (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 100)
+  (data (i32.const 2000) "sub")
+  (data (i32.const 2010) "new.txt")
+  (data (i32.const 2100) "\00\0c\00\00\64\00\00\00")
+  (func (export "inputBuffer") (result i32) (i32.const %[1]d))
+  (func (export "outputBuffer") (result i32) (i32.const %[2]d))
+  (func (export "hostInputBuffer") (result i32) (i32.const %[3]d))
+  (func (export "hostOutputBuffer") (result i32) (i32.const %[4]d))
+  (func (export "wasmy_abi_version") (result i32) (i32.const 2))
+  (func (export "mkdir") (param $fd i32) (result i32)
+    (call $mkdir (local.get $fd) (i32.const 2000) (i32.const 3)))
+  (func (export "open") (param $fd i32) (param $oflags i32) (param $rights i64) (result i32)
+    (call $path_open (local.get $fd) (i32.const 0) (i32.const 2010) (i32.const 7)
+      (local.get $oflags) (local.get $rights) (i64.const 0) (i32.const 0) (i32.const 2200)))
+  (func (export "writeOpened") (result i32)
+    (call $fd_write (i32.load (i32.const 2200)) (i32.const 2100) (i32.const 1) (i32.const 2300)))
+  (func (export "envCount") (result i32)
+    (drop (call $environ_sizes (i32.const 2300) (i32.const 2304)))
+    (i32.load (i32.const 2300)))
+  (func (export "argCount") (result i32)
+    (drop (call $args_sizes (i32.const 2300) (i32.const 2304)))
+    (i32.load (i32.const 2300)))
+  (func (export "readStdin") (result i32)
+    (drop (call $fd_read (i32.const 0) (i32.const 2100) (i32.const 1) (i32.const 2300)))
+    (i32.load (i32.const 2300)))
+)`
+
+const (
+	oflagCreat = 1
+	oflagTrunc = 8
+	rightRW    = rightFdRead | 1<<6
+)
+
+func TestWASIOptions(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testWASIGuestWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ro, rw := t.TempDir(), t.TempDir()
+	err = os.WriteFile(filepath.Join(ro, "new.txt"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ReadOnlyFS(ro).Dir(rw, "/rw", ReadWrite).
+		Env("A", "1").Env("B", "2").
+		Args("plugin", "-v").
+		Stdin(strings.NewReader("hello"))
+
+	r := &Runner{WASI: opts}
+	err = r.WarmUp(engine, module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(name string, args ...interface{}) int32 {
+		t.Helper()
+		ret, err := r.instance.GetFunc(r.store, name).Call(r.store, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ret.(int32)
+	}
+
+	const roFd, rwFd = firstPreopenFd, firstPreopenFd + 1
+
+	// reading is fine in both dirs, changing anything only in the read-write one
+	if errno := call("open", int32(roFd), int32(0), int64(rightFdRead)); errno != 0 {
+		t.Errorf("expected to read from the read-only dir, got errno %d", errno)
+	}
+
+	// asking for write access gets a file that can only be read
+	if errno := call("open", int32(roFd), int32(0), int64(rightRW)); errno == 0 && call("writeOpened") == 0 {
+		t.Errorf("expected writing to fail in the read-only dir")
+	}
+
+	if errno := call("open", int32(roFd), int32(oflagTrunc), int64(rightRW)); errno == 0 {
+		t.Errorf("expected truncating to fail in the read-only dir")
+	}
+
+	if errno := call("open", int32(roFd), int32(oflagCreat), int64(rightRW)); errno == 0 {
+		t.Errorf("expected creating a file to fail in the read-only dir")
+	}
+
+	if errno := call("mkdir", int32(roFd)); errno == 0 {
+		t.Errorf("expected mkdir to fail in the read-only dir")
+	}
+
+	if errno := call("mkdir", int32(rwFd)); errno != 0 {
+		t.Errorf("expected mkdir to work in the read-write dir, got errno %d", errno)
+	}
+
+	if errno := call("open", int32(rwFd), int32(oflagCreat), int64(rightRW)); errno != 0 {
+		t.Errorf("expected to create a file in the read-write dir, got errno %d", errno)
+	}
+
+	for _, path := range []string{filepath.Join(rw, "sub"), filepath.Join(rw, "new.txt")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected the guest to create %s: %v", path, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(ro, "sub")); err == nil {
+		t.Errorf("the guest changed the read-only dir")
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(ro, "new.txt")); string(data) != "x" {
+		t.Errorf("the guest changed a file in the read-only dir: %q", data)
+	}
+
+	if n := call("envCount"); n != 2 {
+		t.Errorf("expected 2 env vars, got %d", n)
+	}
+
+	if n := call("argCount"); n != 2 {
+		t.Errorf("expected 2 args, got %d", n)
+	}
+
+	if n := call("readStdin"); n != 5 {
+		t.Errorf("expected to read 5 bytes from stdin, got %d", n)
+	}
+
+	// a new instance gets the same stdin
+	err = r.WarmUp(engine, module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := call("readStdin"); n != 5 {
+		t.Errorf("expected to read 5 bytes from stdin again, got %d", n)
+	}
+}
+
+func TestWASIOptionsSandboxed(t *testing.T) {
+	engine := GetEngine()
+	wasm, err := wasmtime.Wat2Wasm(fmt.Sprintf(testWASIGuestWAT,
+		testInputBuffer, testOutputBuffer, testHostInputBuffer, testHostOutputBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := wasmtime.NewModule(engine, wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("WASMY_ALLOWED", "yes")
+	r := &Runner{WASI: Sandboxed().AllowEnv("WASMY_ALLOWED", "WASMY_NOT_SET")}
+	err = r.WarmUp(engine, module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := r.instance.GetFunc(r.store, "envCount").Call(r.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret.(int32) != 1 {
+		t.Errorf("expected only the allowed env var, got %d", ret)
+	}
+
+	ret, err = r.instance.GetFunc(r.store, "mkdir").Call(r.store, int32(firstPreopenFd))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret.(int32) == 0 {
+		t.Errorf("expected a sandboxed guest to have no dirs")
+	}
+}
+
+func TestWASIConflict(t *testing.T) {
+	engine := GetEngine()
+	r := &Runner{WASI: ReadOnlyFS(t.TempDir())}
+
+	// a raw WasiConfig would drop the read-only restriction
+	err := r.WarmUp(engine, testModule(t, engine, &shared_types.Payload{Data: "ok"}), wasmtime.NewWasiConfig())
+	if !errors.Is(err, ErrWASIConflict) {
+		t.Fatalf("expected ErrWASIConflict, got %v", err)
+	}
+}
+
+func TestWASIHelperModule(t *testing.T) {
+	engine := GetEngine()
+	first, err := wasiHelperModule(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := wasiHelperModule(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Fatal("expected the helper to be compiled once per engine")
+	}
+}
@@ -0,0 +1,17 @@
+package shared_types
+
+// ABI versions of the wire format between host and guest, a guest reports the one
+// it was built against through the `wasmy_abi_version` export
+const (
+	// ABIv1 modules use fixed FUNCBUFFER_SIZE buffers and send an envelope for
+	// every call, even one without args or result
+	ABIv1 int32 = 1
+	// ABIv2 sends an empty envelope as a length of 0
+	ABIv2 int32 = 2
+	// ABIv3 allocates buffers for every call through `wasmy_alloc` and lets the
+	// guest pick its codec through `wasmy_codec`
+	ABIv3 int32 = 3
+
+	// ABIVersion is the version this package implements
+	ABIVersion = ABIv3
+)
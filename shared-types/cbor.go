@@ -0,0 +1,596 @@
+package shared_types
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// cborCodec is a small CBOR (RFC 8949) codec that covers the values the envelopes
+// can hold: the generic values ToWire produces, []byte and time.Time. The envelopes
+// are maps with the same keys as their msgpack encoding.
+//
+// It is hand written because this package is compiled into TinyGo guests, and the
+// CBOR libraries (e.g. fxamacker/cbor) lean on reflection and unsafe tricks that
+// TinyGo doesn't support. runner/tinygo_test.go builds a guest to keep it that way.
+type cborCodec struct{}
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+const (
+	// cborIndefinite is the additional info of an indefinite length item
+	cborIndefinite = 31
+	cborBreak      = 0xff
+
+	// cborMaxDepth stops hostile input from nesting deep enough to blow the stack
+	cborMaxDepth = 512
+)
+
+var errCBORTruncated = errors.New("shared_types: truncated CBOR data")
+
+func (cborCodec) ID() CodecID {
+	return CodecCBOR
+}
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	switch e := v.(type) {
+	case *Args:
+		b := cborHead(nil, cborMap, 1)
+		b = cborHead(b, cborText, 4)
+		b = append(b, "args"...)
+		return cborAppend(b, e.Args, 0)
+	case *Payload:
+		b := cborHead(nil, cborMap, 3)
+		b = append(cborHead(b, cborText, 4), "data"...)
+		b, err := cborAppend(b, e.Data, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(cborHead(b, cborText, 4), "meta"...)
+		b = cborAppendStrings(b, e.Meta)
+
+		b = append(cborHead(b, cborText, 5), "error"...)
+		if e.Error == nil {
+			return append(b, 0xf6), nil
+		}
+
+		b = cborHead(b, cborMap, 3)
+		b = append(cborHead(b, cborText, 4), "code"...)
+		b = cborAppendText(b, e.Error.Code)
+		b = append(cborHead(b, cborText, 7), "message"...)
+		b = cborAppendText(b, e.Error.Message)
+		b = append(cborHead(b, cborText, 7), "details"...)
+		return cborAppendStrings(b, e.Error.Details), nil
+	}
+
+	return nil, envelopeErr(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	d := &cborDecoder{data: data}
+	top, err := d.value(0)
+	if err != nil {
+		return err
+	}
+
+	if d.pos < len(data) {
+		return fmt.Errorf("shared_types: %d trailing bytes after the CBOR envelope", len(data)-d.pos)
+	}
+
+	m, ok := top.(map[string]interface{})
+	if !ok && top != nil {
+		return fmt.Errorf("shared_types: CBOR envelope is a %T, not a map", top)
+	}
+
+	switch e := v.(type) {
+	case *Args:
+		switch args := m["args"].(type) {
+		case nil:
+			e.Args = nil
+		case []interface{}:
+			e.Args = args
+		default:
+			return fmt.Errorf("shared_types: CBOR args is a %T, not an array", args)
+		}
+	case *Payload:
+		e.Data = m["data"]
+
+		e.Meta, err = cborStrings(m["meta"], "meta")
+		if err != nil {
+			return err
+		}
+
+		e.Error = nil
+		if m["error"] == nil {
+			return nil
+		}
+
+		em, ok := m["error"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("shared_types: CBOR error is a %T, not a map", m["error"])
+		}
+
+		e.Error = &Error{}
+		e.Error.Code, _ = em["code"].(string)
+		e.Error.Message, _ = em["message"].(string)
+		e.Error.Details, err = cborStrings(em["details"], "error details")
+		if err != nil {
+			return err
+		}
+	default:
+		return envelopeErr(v)
+	}
+
+	return nil
+}
+
+// cborHead appends the head of an item of the given major type and argument
+func cborHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return cborAppendUint32(append(b, major|26), uint32(n))
+	}
+
+	return cborAppendUint64(append(b, major|27), n)
+}
+
+func cborAppendUint32(b []byte, n uint32) []byte {
+	return append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func cborAppendUint64(b []byte, n uint64) []byte {
+	return cborAppendUint32(cborAppendUint32(b, uint32(n>>32)), uint32(n))
+}
+
+func cborAppendText(b []byte, s string) []byte {
+	return append(cborHead(b, cborText, uint64(len(s))), s...)
+}
+
+func cborAppendStrings(b []byte, m map[string]string) []byte {
+	if m == nil {
+		return append(b, 0xf6)
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = cborHead(b, cborMap, uint64(len(keys)))
+	for _, k := range keys {
+		b = cborAppendText(b, k)
+		b = cborAppendText(b, m[k])
+	}
+
+	return b
+}
+
+func cborAppendInt(b []byte, n int64) []byte {
+	if n < 0 {
+		return cborHead(b, cborNegInt, uint64(-1-n))
+	}
+
+	return cborHead(b, cborUint, uint64(n))
+}
+
+// cborAppend appends v, values that aren't generic are converted with ToWire
+func cborAppend(b []byte, v interface{}, depth int) ([]byte, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("shared_types: value nested deeper than %d levels", cborMaxDepth)
+	}
+
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if t {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case string:
+		return cborAppendText(b, t), nil
+	case []byte:
+		return append(cborHead(b, cborBytes, uint64(len(t))), t...), nil
+	case int:
+		return cborAppendInt(b, int64(t)), nil
+	case int8:
+		return cborAppendInt(b, int64(t)), nil
+	case int16:
+		return cborAppendInt(b, int64(t)), nil
+	case int32:
+		return cborAppendInt(b, int64(t)), nil
+	case int64:
+		return cborAppendInt(b, t), nil
+	case uint:
+		return cborHead(b, cborUint, uint64(t)), nil
+	case uint8:
+		return cborHead(b, cborUint, uint64(t)), nil
+	case uint16:
+		return cborHead(b, cborUint, uint64(t)), nil
+	case uint32:
+		return cborHead(b, cborUint, uint64(t)), nil
+	case uint64:
+		return cborHead(b, cborUint, t), nil
+	case float32:
+		return cborAppendUint32(append(b, cborSimple<<5|26), math.Float32bits(t)), nil
+	case float64:
+		return cborAppendUint64(append(b, cborSimple<<5|27), math.Float64bits(t)), nil
+	case time.Time:
+		b = cborHead(b, cborTag, 0)
+		return cborAppendText(b, t.Format(time.RFC3339Nano)), nil
+	case []interface{}:
+		b = cborHead(b, cborArray, uint64(len(t)))
+		for _, item := range t {
+			var err error
+			b, err = cborAppend(b, item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = cborHead(b, cborMap, uint64(len(keys)))
+		for _, k := range keys {
+			var err error
+			b, err = cborAppend(cborAppendText(b, k), t[k], depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		return cborAppendStrings(b, t), nil
+	case msgp.Marshaler:
+		// msgp generated types are sent the way msgpack would decode them
+		enc, err := t.MarshalMsg(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		generic, _, err := msgp.ReadIntfBytes(enc)
+		if err != nil {
+			return nil, err
+		}
+
+		return cborAppend(b, generic, depth+1)
+	}
+
+	generic, err := ToWire(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if reflect.TypeOf(generic) == reflect.TypeOf(v) {
+		return nil, fmt.Errorf("shared_types: cannot encode %T as CBOR", v)
+	}
+
+	return cborAppend(b, generic, depth+1)
+}
+
+// cborStrings converts a decoded map into a map[string]string
+func cborStrings(v interface{}, what string) (map[string]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("shared_types: CBOR %s is a %T, not a map", what, v)
+	}
+
+	out := make(map[string]string, len(m))
+	for k, item := range m {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("shared_types: CBOR %s value %q is a %T, not a string", what, k, item)
+		}
+		out[k] = s
+	}
+
+	return out, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBORTruncated
+	}
+
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads the head of the next item, info is cborIndefinite for an indefinite
+// length string, array or map
+func (d *cborDecoder) head() (major byte, info byte, n uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		b, err = d.next(1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(b[0]), nil
+	case info == 25:
+		b, err = d.next(2)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err = d.next(4)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err = d.next(8)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, binary.BigEndian.Uint64(b), nil
+	case info == cborIndefinite && major >= cborBytes && major <= cborMap:
+		return major, info, 0, nil
+	}
+
+	return 0, 0, 0, fmt.Errorf("shared_types: invalid CBOR item 0x%02x", b[0])
+}
+
+// isBreak consumes the break that ends an indefinite length item
+func (d *cborDecoder) isBreak() (bool, error) {
+	if d.pos >= len(d.data) {
+		return false, errCBORTruncated
+	}
+
+	if d.data[d.pos] == cborBreak {
+		d.pos++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("shared_types: CBOR data nested deeper than %d levels", cborMaxDepth)
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	indefinite := info == cborIndefinite
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("shared_types: CBOR integer -1-%d overflows int64", n)
+		}
+		return -1 - int64(n), nil
+	case cborBytes, cborText:
+		var buf []byte
+		if indefinite {
+			buf, err = d.chunks(major)
+		} else {
+			var b []byte
+			b, err = d.next(n)
+			buf = append([]byte(nil), b...)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if major == cborText {
+			return string(buf), nil
+		}
+		return buf, nil
+	case cborArray:
+		// every item takes at least a byte, a bigger length is bogus
+		if !indefinite && n > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+
+		out := make([]interface{}, 0, n)
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite {
+				done, err := d.isBreak()
+				if err != nil {
+					return nil, err
+				}
+				if done {
+					break
+				}
+			}
+
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, item)
+		}
+		return out, nil
+	case cborMap:
+		if !indefinite && n > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBORTruncated
+		}
+
+		out := make(map[string]interface{}, n)
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite {
+				done, err := d.isBreak()
+				if err != nil {
+					return nil, err
+				}
+				if done {
+					break
+				}
+			}
+
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("shared_types: CBOR map key is a %T, keys must be strings", key)
+			}
+
+			out[k], err = d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case cborTag:
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return cborTagged(n, item)
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfToFloat32(uint16(n)), nil
+	case 26:
+		return math.Float32frombits(uint32(n)), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+
+	return nil, fmt.Errorf("shared_types: unsupported CBOR simple value %d", n)
+}
+
+// chunks reads the chunks of an indefinite length byte or text string
+func (d *cborDecoder) chunks(major byte) ([]byte, error) {
+	var buf []byte
+	for {
+		done, err := d.isBreak()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return buf, nil
+		}
+
+		m, info, n, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+
+		if m != major || info == cborIndefinite {
+			return nil, fmt.Errorf("shared_types: invalid chunk in indefinite length CBOR string")
+		}
+
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+}
+
+// cborTagged converts the date/time tags, other tags are dropped
+func cborTagged(tag uint64, item interface{}) (interface{}, error) {
+	switch tag {
+	case 0:
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("shared_types: CBOR date/time is a %T, not a string", item)
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case 1:
+		switch t := item.(type) {
+		case int64:
+			return time.Unix(t, 0), nil
+		case float32:
+			return time.Unix(0, int64(float64(t)*float64(time.Second))), nil
+		case float64:
+			return time.Unix(0, int64(t*float64(time.Second))), nil
+		}
+		return nil, fmt.Errorf("shared_types: CBOR epoch time is a %T, not a number", item)
+	}
+
+	return item, nil
+}
+
+// halfToFloat32 converts an IEEE 754 half precision float
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0:
+		// zero and subnormals
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
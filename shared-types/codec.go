@@ -0,0 +1,130 @@
+package shared_types
+
+import (
+	"fmt"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Codec encodes the Args and Payload envelopes sent between host and guest. Both
+// sides have to use the same codec, the host asks the guest which one it speaks
+// through the `wasmy_codec` export when the module is warmed up.
+type Codec interface {
+	// ID identifies the codec in the `wasmy_codec` negotiation
+	ID() CodecID
+	// Name is the name of the codec, e.g. "msgpack"
+	Name() string
+	// Marshal encodes v, which is an *Args or a *Payload
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v, which is an *Args or a *Payload
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// CodecID identifies a codec on the wire
+type CodecID int32
+
+// IDs of the built-in codecs, a guest without a `wasmy_codec` export speaks msgpack
+const (
+	CodecMsgpack CodecID = 0
+	CodecJSON    CodecID = 1
+	CodecCBOR    CodecID = 2
+)
+
+var (
+	// Msgpack is the default codec, it uses the msgp generated code of the envelopes
+	Msgpack Codec = msgpackCodec{}
+
+	// JSON encodes the envelopes as JSON, it has no binary or time types so []byte
+	// values arrive as base64 strings and time.Time values as RFC 3339 strings
+	JSON Codec = jsonCodec{}
+
+	// CBOR encodes the envelopes as CBOR (RFC 8949), time.Time values are sent as
+	// tag 0 date/time strings
+	CBOR Codec = cborCodec{}
+)
+
+// Codecs are the built-in codecs, in order of preference
+var Codecs = []Codec{Msgpack, JSON, CBOR}
+
+// CodecByID returns the built-in codec with the given id
+func CodecByID(id CodecID) (Codec, bool) {
+	for _, c := range Codecs {
+		if c.ID() == id {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// CodecByName returns the built-in codec with the given name
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+// CodecMask is the set of codecs a host offers in the `wasmy_codec` negotiation,
+// bit n is set for the codec with ID n
+func CodecMask(codecs ...Codec) int32 {
+	var mask int32
+	for _, c := range codecs {
+		mask |= 1 << c.ID()
+	}
+
+	return mask
+}
+
+// OrDefault returns c, or Msgpack when c is nil
+func OrDefault(c Codec) Codec {
+	if c == nil {
+		return Msgpack
+	}
+
+	return c
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() CodecID {
+	return CodecMsgpack
+}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(msgp.Marshaler)
+	if !ok {
+		return nil, envelopeErr(v)
+	}
+
+	return m.MarshalMsg(nil)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(msgp.Unmarshaler)
+	if !ok {
+		return envelopeErr(v)
+	}
+
+	rest, err := m.UnmarshalMsg(data)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) > 0 {
+		return fmt.Errorf("shared_types: %d trailing bytes after the msgpack envelope", len(rest))
+	}
+
+	return nil
+}
+
+func envelopeErr(v interface{}) error {
+	return fmt.Errorf("shared_types: cannot encode %T, need an *Args or a *Payload", v)
+}
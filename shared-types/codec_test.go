@@ -0,0 +1,150 @@
+package shared_types
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCodecs(t *testing.T) {
+	seen := time.Date(2022, 7, 1, 12, 30, 0, 500, time.UTC)
+
+	for _, c := range Codecs {
+		t.Run(c.Name(), func(t *testing.T) {
+			if got, _ := CodecByName(c.Name()); got != c {
+				t.Fatalf("expected %s to be registered", c.Name())
+			}
+
+			user := wireUser{
+				Name:    "martin",
+				Age:     -42,
+				Score:   1.5,
+				Tags:    []string{"a", "b"},
+				Labels:  map[string]uint16{"x": 7},
+				Address: &wireAddress{City: "london"},
+				Raw:     []byte{0, 1, 2},
+				Seen:    seen,
+			}
+
+			wire, err := ToWire(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// JSON has no binary or time types
+			if c == JSON {
+				m := wire.(map[string]interface{})
+				m["raw"], m["seen"] = nil, nil
+				user.Raw, user.Seen = nil, time.Time{}
+			}
+
+			enc, err := c.Marshal(&Args{Args: []interface{}{wire, uint64(1 << 63), nil}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args := &Args{}
+			err = c.Unmarshal(enc, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(args.Args) != 3 || args.Args[1] != uint64(1<<63) || args.Args[2] != nil {
+				t.Fatalf("unexpected args %#v", args.Args)
+			}
+
+			var got wireUser
+			err = FromWire(args.Args[0], &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// msgpack decodes times in the local time zone
+			if !got.Seen.Equal(user.Seen) {
+				t.Fatalf("expected %v, got %v", user.Seen, got.Seen)
+			}
+			got.Seen = user.Seen
+
+			if !reflect.DeepEqual(got, user) {
+				t.Fatalf("expected %+v, got %+v", user, got)
+			}
+
+			in := &Payload{
+				Data:  []interface{}{int64(1), "two", 3.5},
+				Meta:  map[string]string{"k": "v"},
+				Error: &Error{Code: ErrCodeHost, Message: "boom", Details: map[string]string{"d": "e"}},
+			}
+
+			enc, err = c.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := &Payload{}
+			err = c.Unmarshal(enc, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(out, in) {
+				t.Fatalf("expected %+v, got %+v", in, out)
+			}
+
+			// every codec rejects data after the envelope
+			err = c.Unmarshal(append(enc, enc...), &Payload{})
+			if err == nil {
+				t.Fatal("expected trailing data to be rejected")
+			}
+
+			_, err = c.Marshal("not an envelope")
+			if err == nil {
+				t.Fatal("expected an error for a value that isn't an envelope")
+			}
+		})
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	// {"args": [1.0 as a half float, (_ "ab" "c"), [_ 1, -2], 0("2013-03-21T20:04:00Z")]}
+	data := []byte{
+		0xa1, 0x64, 'a', 'r', 'g', 's', 0x84,
+		0xf9, 0x3c, 0x00,
+		0x7f, 0x62, 'a', 'b', 0x61, 'c', 0xff,
+		0x9f, 0x01, 0x21, 0xff,
+		0xc0, 0x74, '2', '0', '1', '3', '-', '0', '3', '-', '2', '1', 'T', '2', '0', ':', '0', '4', ':', '0', '0', 'Z',
+	}
+
+	args := &Args{}
+	err := CBOR.Unmarshal(data, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{
+		float32(1),
+		"abc",
+		[]interface{}{int64(1), int64(-2)},
+		time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC),
+	}
+
+	if !reflect.DeepEqual(args.Args, want) {
+		t.Fatalf("expected %#v, got %#v", want, args.Args)
+	}
+
+	err = CBOR.Unmarshal(append(data[:len(data):len(data)], 0x00), args)
+	if err == nil {
+		t.Fatal("expected trailing bytes to be rejected")
+	}
+
+	err = CBOR.Unmarshal(data[:len(data)-3], args)
+	if !errors.Is(err, errCBORTruncated) {
+		t.Fatalf("expected truncated data to be rejected, got %v", err)
+	}
+
+	// an array that claims more items than there are bytes
+	err = CBOR.Unmarshal([]byte{0xa1, 0x64, 'a', 'r', 'g', 's', 0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, args)
+	if !errors.Is(err, errCBORTruncated) {
+		t.Fatalf("expected a bogus length to be rejected, got %v", err)
+	}
+}
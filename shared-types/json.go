@@ -0,0 +1,103 @@
+package shared_types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+)
+
+var errJSONTrailing = errors.New("shared_types: trailing data after the JSON envelope")
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() CodecID {
+	return CodecJSON
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v.(type) {
+	case *Args, *Payload:
+		return json.Marshal(v)
+	}
+
+	return nil, envelopeErr(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	switch e := v.(type) {
+	case *Args:
+		err := decodeAll(dec, e)
+		if err != nil {
+			return err
+		}
+
+		for i := range e.Args {
+			e.Args[i] = fromJSONNumbers(e.Args[i])
+		}
+	case *Payload:
+		err := decodeAll(dec, e)
+		if err != nil {
+			return err
+		}
+
+		e.Data = fromJSONNumbers(e.Data)
+	default:
+		return envelopeErr(v)
+	}
+
+	return nil
+}
+
+// decodeAll decodes a single value into v and rejects anything after it, like the
+// msgpack and CBOR codecs do
+func decodeAll(dec *json.Decoder, v interface{}) error {
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = dec.Token()
+	if err != io.EOF {
+		return errJSONTrailing
+	}
+
+	return nil
+}
+
+// fromJSONNumbers turns the numbers in a decoded JSON value into the int64, uint64
+// and float64 values msgpack decodes them as, so FromWire works the same with
+// either codec
+func fromJSONNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return n
+		}
+
+		if n, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return n
+		}
+
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		for i := range t {
+			t[i] = fromJSONNumbers(t[i])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = fromJSONNumbers(t[k])
+		}
+	}
+
+	return v
+}
@@ -1,13 +1,77 @@
 //go:generate msgp
 package shared_types
 
+import "errors"
+
 //tinyjson:json
 type Args struct {
-	Args []interface{} `msg:"args"`
+	Args []interface{} `msg:"args" json:"args"`
 }
 
 //tinyjson:json
 type Payload struct {
-	Data interface{}       `msg:"data"`
-	Meta map[string]string `msg:"meta"`
+	Data  interface{}       `msg:"data" json:"data"`
+	Meta  map[string]string `msg:"meta" json:"meta"`
+	Error *Error            `msg:"error" json:"error"`
+
+	// Stats is filled in by the host runner and never sent over the wire
+	Stats *CallStats `msg:"-" json:"-"`
+	// Output is filled in by the host runner when it captures guest output, it
+	// is never sent over the wire either
+	Output *CallOutput `msg:"-" json:"-"`
+}
+
+//msgp:ignore CallStats CallOutput
+
+// CallStats describes the resources a guest call used
+type CallStats struct {
+	// FuelConsumed is only set when the engine has fuel consumption enabled
+	FuelConsumed uint64
+}
+
+// CallOutput is what a guest wrote to stdout and stderr during a call
+type CallOutput struct {
+	// CallID tags the lines of the call in the captured log
+	CallID uint64
+	Stdout []byte
+	Stderr []byte
+}
+
+// ErrPayloadTooLarge is returned by the guest and host helpers when a length does
+// not fit in the buffer it refers to, interfaces and runner export it under the
+// same name so errors.Is matches on either side
+var ErrPayloadTooLarge = errors.New("payload too large for I/O buffer")
+
+// Error codes set by the wasmy wrappers, guest functions can use their own codes
+const (
+	// ErrCodeGuest is used for errors returned by a guest function
+	ErrCodeGuest = "guest_error"
+	// ErrCodeDecode is used when the guest fails to decode its input
+	ErrCodeDecode = "decode_error"
+	// ErrCodeEncode is used when the guest fails to encode its output
+	ErrCodeEncode = "encode_error"
+	// ErrCodeBadArgs is used when a typed function gets the wrong number of
+	// arguments, or a typed import gets no result
+	ErrCodeBadArgs = "bad_args"
+	// ErrCodeHost is used for errors returned by a host function
+	ErrCodeHost = "host_error"
+	// ErrCodePermissionDenied is used when the host function isn't granted to
+	// the guest, see runner.Policy
+	ErrCodePermissionDenied = "permission_denied"
+)
+
+// Error is the error envelope of a Payload, it is set instead of Data when a
+// call fails
+//
+//tinyjson:json
+type Error struct {
+	Code    string            `msg:"code" json:"code"`
+	Message string            `msg:"message" json:"message"`
+	Details map[string]string `msg:"details" json:"details"`
+}
+
+// Error implements the error interface so guest and host functions can return
+// an *Error to control the code and details sent to the other side
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
 }
@@ -152,6 +152,229 @@ func (z *Args) Msgsize() (s int) {
 	return
 }
 
+// DecodeMsg implements msgp.Decodable
+func (z *Error) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		err = msgp.WrapError(err)
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			err = msgp.WrapError(err)
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "code":
+			z.Code, err = dc.ReadString()
+			if err != nil {
+				err = msgp.WrapError(err, "Code")
+				return
+			}
+		case "message":
+			z.Message, err = dc.ReadString()
+			if err != nil {
+				err = msgp.WrapError(err, "Message")
+				return
+			}
+		case "details":
+			var zb0002 uint32
+			zb0002, err = dc.ReadMapHeader()
+			if err != nil {
+				err = msgp.WrapError(err, "Details")
+				return
+			}
+			if z.Details == nil {
+				z.Details = make(map[string]string, zb0002)
+			} else if len(z.Details) > 0 {
+				for key := range z.Details {
+					delete(z.Details, key)
+				}
+			}
+			for zb0002 > 0 {
+				zb0002--
+				var za0001 string
+				var za0002 string
+				za0001, err = dc.ReadString()
+				if err != nil {
+					err = msgp.WrapError(err, "Details")
+					return
+				}
+				za0002, err = dc.ReadString()
+				if err != nil {
+					err = msgp.WrapError(err, "Details", za0001)
+					return
+				}
+				z.Details[za0001] = za0002
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				err = msgp.WrapError(err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *Error) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 3
+	// write "code"
+	err = en.Append(0x83, 0xa4, 0x63, 0x6f, 0x64, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Code)
+	if err != nil {
+		err = msgp.WrapError(err, "Code")
+		return
+	}
+	// write "message"
+	err = en.Append(0xa7, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Message)
+	if err != nil {
+		err = msgp.WrapError(err, "Message")
+		return
+	}
+	// write "details"
+	err = en.Append(0xa7, 0x64, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteMapHeader(uint32(len(z.Details)))
+	if err != nil {
+		err = msgp.WrapError(err, "Details")
+		return
+	}
+	for za0001, za0002 := range z.Details {
+		err = en.WriteString(za0001)
+		if err != nil {
+			err = msgp.WrapError(err, "Details")
+			return
+		}
+		err = en.WriteString(za0002)
+		if err != nil {
+			err = msgp.WrapError(err, "Details", za0001)
+			return
+		}
+	}
+	return
+}
+
+// MarshalMsg implements msgp.Marshaler
+func (z *Error) MarshalMsg(b []byte) (o []byte, err error) {
+	o = msgp.Require(b, z.Msgsize())
+	// map header, size 3
+	// string "code"
+	o = append(o, 0x83, 0xa4, 0x63, 0x6f, 0x64, 0x65)
+	o = msgp.AppendString(o, z.Code)
+	// string "message"
+	o = append(o, 0xa7, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65)
+	o = msgp.AppendString(o, z.Message)
+	// string "details"
+	o = append(o, 0xa7, 0x64, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73)
+	o = msgp.AppendMapHeader(o, uint32(len(z.Details)))
+	for za0001, za0002 := range z.Details {
+		o = msgp.AppendString(o, za0001)
+		o = msgp.AppendString(o, za0002)
+	}
+	return
+}
+
+// UnmarshalMsg implements msgp.Unmarshaler
+func (z *Error) UnmarshalMsg(bts []byte) (o []byte, err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, bts, err = msgp.ReadMapHeaderBytes(bts)
+	if err != nil {
+		err = msgp.WrapError(err)
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, bts, err = msgp.ReadMapKeyZC(bts)
+		if err != nil {
+			err = msgp.WrapError(err)
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "code":
+			z.Code, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Code")
+				return
+			}
+		case "message":
+			z.Message, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Message")
+				return
+			}
+		case "details":
+			var zb0002 uint32
+			zb0002, bts, err = msgp.ReadMapHeaderBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Details")
+				return
+			}
+			if z.Details == nil {
+				z.Details = make(map[string]string, zb0002)
+			} else if len(z.Details) > 0 {
+				for key := range z.Details {
+					delete(z.Details, key)
+				}
+			}
+			for zb0002 > 0 {
+				var za0001 string
+				var za0002 string
+				zb0002--
+				za0001, bts, err = msgp.ReadStringBytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Details")
+					return
+				}
+				za0002, bts, err = msgp.ReadStringBytes(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Details", za0001)
+					return
+				}
+				z.Details[za0001] = za0002
+			}
+		default:
+			bts, err = msgp.Skip(bts)
+			if err != nil {
+				err = msgp.WrapError(err)
+				return
+			}
+		}
+	}
+	o = bts
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *Error) Msgsize() (s int) {
+	s = 1 + 5 + msgp.StringPrefixSize + len(z.Code) + 8 + msgp.StringPrefixSize + len(z.Message) + 8 + msgp.MapHeaderSize
+	if z.Details != nil {
+		for za0001, za0002 := range z.Details {
+			_ = za0002
+			s += msgp.StringPrefixSize + len(za0001) + msgp.StringPrefixSize + len(za0002)
+		}
+	}
+	return
+}
+
 // DecodeMsg implements msgp.Decodable
 func (z *Payload) DecodeMsg(dc *msgp.Reader) (err error) {
 	var field []byte
@@ -206,6 +429,24 @@ func (z *Payload) DecodeMsg(dc *msgp.Reader) (err error) {
 				}
 				z.Meta[za0001] = za0002
 			}
+		case "error":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					err = msgp.WrapError(err, "Error")
+					return
+				}
+				z.Error = nil
+			} else {
+				if z.Error == nil {
+					z.Error = new(Error)
+				}
+				err = z.Error.DecodeMsg(dc)
+				if err != nil {
+					err = msgp.WrapError(err, "Error")
+					return
+				}
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -219,9 +460,9 @@ func (z *Payload) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Payload) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 2
+	// map header, size 3
 	// write "data"
-	err = en.Append(0x82, 0xa4, 0x64, 0x61, 0x74, 0x61)
+	err = en.Append(0x83, 0xa4, 0x64, 0x61, 0x74, 0x61)
 	if err != nil {
 		return
 	}
@@ -252,15 +493,32 @@ func (z *Payload) EncodeMsg(en *msgp.Writer) (err error) {
 			return
 		}
 	}
+	// write "error"
+	err = en.Append(0xa5, 0x65, 0x72, 0x72, 0x6f, 0x72)
+	if err != nil {
+		return
+	}
+	if z.Error == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.Error.EncodeMsg(en)
+		if err != nil {
+			err = msgp.WrapError(err, "Error")
+			return
+		}
+	}
 	return
 }
 
 // MarshalMsg implements msgp.Marshaler
 func (z *Payload) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 2
+	// map header, size 3
 	// string "data"
-	o = append(o, 0x82, 0xa4, 0x64, 0x61, 0x74, 0x61)
+	o = append(o, 0x83, 0xa4, 0x64, 0x61, 0x74, 0x61)
 	o, err = msgp.AppendIntf(o, z.Data)
 	if err != nil {
 		err = msgp.WrapError(err, "Data")
@@ -273,6 +531,17 @@ func (z *Payload) MarshalMsg(b []byte) (o []byte, err error) {
 		o = msgp.AppendString(o, za0001)
 		o = msgp.AppendString(o, za0002)
 	}
+	// string "error"
+	o = append(o, 0xa5, 0x65, 0x72, 0x72, 0x6f, 0x72)
+	if z.Error == nil {
+		o = msgp.AppendNil(o)
+	} else {
+		o, err = z.Error.MarshalMsg(o)
+		if err != nil {
+			err = msgp.WrapError(err, "Error")
+			return
+		}
+	}
 	return
 }
 
@@ -330,6 +599,23 @@ func (z *Payload) UnmarshalMsg(bts []byte) (o []byte, err error) {
 				}
 				z.Meta[za0001] = za0002
 			}
+		case "error":
+			if msgp.IsNil(bts) {
+				bts, err = msgp.ReadNilBytes(bts)
+				if err != nil {
+					return
+				}
+				z.Error = nil
+			} else {
+				if z.Error == nil {
+					z.Error = new(Error)
+				}
+				bts, err = z.Error.UnmarshalMsg(bts)
+				if err != nil {
+					err = msgp.WrapError(err, "Error")
+					return
+				}
+			}
 		default:
 			bts, err = msgp.Skip(bts)
 			if err != nil {
@@ -351,5 +637,11 @@ func (z *Payload) Msgsize() (s int) {
 			s += msgp.StringPrefixSize + len(za0001) + msgp.StringPrefixSize + len(za0002)
 		}
 	}
+	s += 6
+	if z.Error == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.Error.Msgsize()
+	}
 	return
 }
@@ -0,0 +1,364 @@
+package shared_types
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// ToWire and FromWire convert concrete Go values to and from the generic values
+// msgp can encode inside the Args and Payload envelopes. Structs become maps keyed
+// by their `msg` tag (or field name), so a struct sent with ToWire on one side can
+// be decoded into a struct with matching tags by FromWire on the other.
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	marshalerType   = reflect.TypeOf((*msgp.Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*msgp.Unmarshaler)(nil)).Elem()
+)
+
+// ToWire converts v into a value that msgp can encode as an interface{}
+func ToWire(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	return toWire(reflect.ValueOf(v), "")
+}
+
+func toWire(v reflect.Value, path string) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+
+	// msgp-generated types encode themselves
+	if v.Type().Implements(marshalerType) {
+		return v.Interface(), nil
+	}
+
+	if v.Type() == timeType {
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return toWire(v.Elem(), path)
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32:
+		return float32(v.Float()), nil
+	case reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			out := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(out), v)
+			return out, nil
+		}
+
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			el, err := toWire(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = el
+		}
+		return out, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("shared_types: cannot encode %s at %s: map keys must be strings", v.Type(), pathOrRoot(path))
+		}
+
+		out := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			el, err := toWire(v.MapIndex(k), path+"."+k.String())
+			if err != nil {
+				return nil, err
+			}
+			out[k.String()] = el
+		}
+		return out, nil
+	case reflect.Struct:
+		t := v.Type()
+		out := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := wireName(t.Field(i))
+			if !ok {
+				continue
+			}
+
+			el, err := toWire(v.Field(i), path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = el
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("shared_types: cannot encode %s at %s", v.Type(), pathOrRoot(path))
+}
+
+// FromWire decodes a value read from a msgp envelope (e.g. Payload.Data) into dst,
+// which must be a non-nil pointer
+func FromWire(src interface{}, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("shared_types: cannot decode into %T, need a non-nil pointer", dst)
+	}
+
+	return fromWire(src, v.Elem(), "")
+}
+
+func fromWire(src interface{}, dst reflect.Value, path string) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	// msgp-generated types decode themselves
+	if dst.CanAddr() && dst.Addr().Type().Implements(unmarshalerType) {
+		enc, err := msgp.AppendIntf(nil, src)
+		if err != nil {
+			return decodeErr(src, dst, path)
+		}
+
+		_, err = dst.Addr().Interface().(msgp.Unmarshaler).UnmarshalMsg(enc)
+		if err != nil {
+			return fmt.Errorf("shared_types: cannot decode %s at %s: %w", dst.Type(), pathOrRoot(path), err)
+		}
+		return nil
+	}
+
+	if dst.Type() == timeType {
+		t, ok := src.(time.Time)
+		if !ok {
+			return decodeErr(src, dst, path)
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	sv := reflect.ValueOf(src)
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if !sv.Type().AssignableTo(dst.Type()) {
+			return decodeErr(src, dst, path)
+		}
+		dst.Set(sv)
+		return nil
+	case reflect.Ptr:
+		el := reflect.New(dst.Type().Elem())
+		err := fromWire(src, el.Elem(), path)
+		if err != nil {
+			return err
+		}
+		dst.Set(el)
+		return nil
+	case reflect.Bool:
+		if sv.Kind() != reflect.Bool {
+			return decodeErr(src, dst, path)
+		}
+		dst.SetBool(sv.Bool())
+		return nil
+	case reflect.String:
+		switch s := src.(type) {
+		case string:
+			dst.SetString(s)
+		case []byte:
+			dst.SetString(string(s))
+		default:
+			return decodeErr(src, dst, path)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch sv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = sv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if sv.Uint() > 1<<63-1 {
+				return overflowErr(src, dst, path)
+			}
+			n = int64(sv.Uint())
+		default:
+			return decodeErr(src, dst, path)
+		}
+		if dst.OverflowInt(n) {
+			return overflowErr(src, dst, path)
+		}
+		dst.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch sv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if sv.Int() < 0 {
+				return overflowErr(src, dst, path)
+			}
+			n = uint64(sv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = sv.Uint()
+		default:
+			return decodeErr(src, dst, path)
+		}
+		if dst.OverflowUint(n) {
+			return overflowErr(src, dst, path)
+		}
+		dst.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch sv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(sv.Float())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetFloat(float64(sv.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			dst.SetFloat(float64(sv.Uint()))
+		default:
+			return decodeErr(src, dst, path)
+		}
+		return nil
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch b := src.(type) {
+			case []byte:
+				dst.SetBytes(append([]byte(nil), b...))
+			case string:
+				dst.SetBytes([]byte(b))
+			default:
+				return decodeErr(src, dst, path)
+			}
+			return nil
+		}
+
+		items, ok := src.([]interface{})
+		if !ok {
+			return decodeErr(src, dst, path)
+		}
+
+		out := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			err := fromWire(item, out.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+		return nil
+	case reflect.Array:
+		items, ok := src.([]interface{})
+		if !ok || len(items) != dst.Len() {
+			return decodeErr(src, dst, path)
+		}
+
+		for i, item := range items {
+			err := fromWire(item, dst.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if dst.Type().Key().Kind() != reflect.String {
+			return decodeErr(src, dst, path)
+		}
+
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return decodeErr(src, dst, path)
+		}
+
+		out := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for k, item := range m {
+			el := reflect.New(dst.Type().Elem()).Elem()
+			err := fromWire(item, el, path+"."+k)
+			if err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), el)
+		}
+		dst.Set(out)
+		return nil
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return decodeErr(src, dst, path)
+		}
+
+		t := dst.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := wireName(t.Field(i))
+			if !ok {
+				continue
+			}
+
+			item, found := m[name]
+			if !found {
+				continue
+			}
+
+			err := fromWire(item, dst.Field(i), path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return decodeErr(src, dst, path)
+}
+
+// wireName returns the map key of a struct field, unexported fields and fields
+// tagged `msg:"-"` are skipped
+func wireName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+
+	tag := f.Tag.Get("msg")
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+
+	switch tag {
+	case "-":
+		return "", false
+	case "":
+		return f.Name, true
+	}
+
+	return tag, true
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "root"
+	}
+
+	return strings.TrimPrefix(path, ".")
+}
+
+func decodeErr(src interface{}, dst reflect.Value, path string) error {
+	return fmt.Errorf("shared_types: cannot decode %T into %s at %s", src, dst.Type(), pathOrRoot(path))
+}
+
+func overflowErr(src interface{}, dst reflect.Value, path string) error {
+	return fmt.Errorf("shared_types: %v overflows %s at %s", src, dst.Type(), pathOrRoot(path))
+}
@@ -0,0 +1,110 @@
+package shared_types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type wireAddress struct {
+	City string `msg:"city"`
+}
+
+type wireUser struct {
+	Name     string            `msg:"name"`
+	Age      int               `msg:"age"`
+	Score    float64           `msg:"score"`
+	Tags     []string          `msg:"tags"`
+	Labels   map[string]uint16 `msg:"labels"`
+	Address  *wireAddress      `msg:"address"`
+	Raw      []byte            `msg:"raw"`
+	Seen     time.Time         `msg:"seen"`
+	Untagged bool
+	Skipped  string `msg:"-"`
+	private  string
+}
+
+// roundTrip sends v through an Args envelope like a host to guest call would
+func roundTrip(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+
+	wire, err := ToWire(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := (&Args{Args: []interface{}{wire}}).MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := &Args{}
+	_, err = args.UnmarshalMsg(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return args.Args[0]
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	in := wireUser{
+		Name:     "martin",
+		Age:      42,
+		Score:    1.5,
+		Tags:     []string{"a", "b"},
+		Labels:   map[string]uint16{"x": 7},
+		Address:  &wireAddress{City: "London"},
+		Raw:      []byte{1, 2, 3},
+		Seen:     time.Unix(1600000000, 0).UTC(),
+		Untagged: true,
+		Skipped:  "not sent",
+		private:  "not sent",
+	}
+
+	out := wireUser{}
+	err := FromWire(roundTrip(t, in), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in.Skipped = ""
+	in.private = ""
+	out.Seen = out.Seen.UTC()
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", in, out)
+	}
+
+	// msgp decodes all ints as int64, FromWire narrows them
+	var n int8
+	err = FromWire(roundTrip(t, 12), &n)
+	if err != nil || n != 12 {
+		t.Fatalf("expected 12, got %d (%v)", n, err)
+	}
+}
+
+func TestWireDecodeErrors(t *testing.T) {
+	out := wireUser{}
+
+	err := FromWire(roundTrip(t, map[string]interface{}{"age": "old"}), &out)
+	if err == nil || !strings.Contains(err.Error(), "at age") {
+		t.Fatalf("expected a decode error for age, got %v", err)
+	}
+
+	var n int8
+	err = FromWire(roundTrip(t, 1000), &n)
+	if err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Fatalf("expected an overflow error, got %v", err)
+	}
+
+	err = FromWire("x", out)
+	if err == nil {
+		t.Fatal("expected an error for a non-pointer destination")
+	}
+
+	_, err = ToWire(map[int]string{1: "x"})
+	if err == nil {
+		t.Fatal("expected an error for non-string map keys")
+	}
+}
@@ -1,3 +1,5 @@
+//go:build tinygo
+
 package main
 
 import (
@@ -6,8 +8,8 @@ import (
 	"github.com/lonelycode/wasmy/interfaces"
 
 	// This MUST be imported to provide boilerplate exports
-	// Note this is not suitable for parallel execution, as you may end up overwriting i/o buffers
-	// for that, implement a Proto for each export
+	// module_params.Proto is shared by every export, each imported function call gets its
+	// own call frame so host functions can safely call back into other exports
 	module_params "github.com/lonelycode/wasmy/module-params"
 )
 
@@ -15,12 +17,13 @@ import (
 // ------------
 
 // sample imported func (see exports/exports.go and example/main.go)
+//
+//go:wasmimport env main.PrintHello
 func PrintHello(int32) int32
 
-// this is the function signature for all exported functions managed by the prototype
-func myFunction(args ...interface{}) (interface{}, map[string]string, error) {
-	name := args[0].(string)
-
+// exported functions managed by the prototype take a single typed argument and
+// return a typed result, see interfaces.WrapExport for the untyped signature
+func myFunction(name string) (string, error) {
 	dt := fmt.Sprintf("hello %s", name)
 
 	fmt.Printf("inside module: %s\n", dt)
@@ -29,7 +32,7 @@ func myFunction(args ...interface{}) (interface{}, map[string]string, error) {
 	// this is defined in the exports/exports.go file
 	doStuff()
 
-	return dt, nil, nil
+	return dt, nil
 }
 
 // MyExport is a function stub to export the wrapped and managed version
@@ -37,17 +40,18 @@ func myFunction(args ...interface{}) (interface{}, map[string]string, error) {
 // the method will remain unexported)
 //export myExport
 func MyExport(inputLen int) int {
-	return interfaces.WrapExport(module_params.Proto, inputLen, myFunction)()
+	return interfaces.ExportTyped(module_params.Proto, inputLen, myFunction)()
 }
 
 // doStuff is an unexported module function that calls an imported method from the host
 func doStuff() {
-	ret, err := interfaces.CallImport(module_params.Proto, PrintHello, "anderson")
+	ret, err := interfaces.ImportTyped[string, string](module_params.Proto, PrintHello, "anderson")
 	if err != nil {
 		fmt.Println(err)
+		return
 	}
 
-	fmt.Printf("host function output from inside module: %s\n", ret.(string))
+	fmt.Printf("host function output from inside module: %s\n", ret)
 }
 
 func main() {}